@@ -1,15 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"urlShortener/internal/analytics"
 	"urlShortener/internal/app"
 	"urlShortener/internal/config"
+	"urlShortener/internal/http-server/handlers/redirect"
+	"urlShortener/internal/lib/aliasrule"
+	"urlShortener/internal/lib/clientip"
+	"urlShortener/internal/lib/geoip"
 	"urlShortener/internal/lib/logger/handlers"
 	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/lib/random"
+	"urlShortener/internal/storage"
+	"urlShortener/internal/storage/memory"
+	"urlShortener/internal/storage/postgres"
 	"urlShortener/internal/storage/sqlite"
+	"urlShortener/internal/sweeper"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 const (
@@ -18,33 +37,242 @@ const (
 	envProd  = "prod"
 )
 
+const (
+	storageSQLite   = "sqlite"
+	storagePostgres = "postgres"
+	storageMemory   = "memory"
+)
+
+const (
+	aliasRandom     = "random"
+	aliasSequential = "sequential"
+	aliasHashids    = "hashids"
+	aliasWords      = "words"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+// Storage is what main needs from a storage backend on top of serving requests.
+type Storage interface {
+	app.Storage
+	analytics.ClickSaver
+	sweeper.ExpiredURLDeleter
+	NextAliasNumber(ctx context.Context) (int64, error)
+	io.Closer
+}
+
 func main() {
 	cfg := config.MustLoad()
 
 	log := setupLogger(cfg.Env)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, log, os.Args[2:]); err != nil {
+			log.Error("failed to migrate", sl.Err(err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.Info("starting url-shortener", slog.String("env", cfg.Env))
 	log.Debug("debug messages are enabled")
 
-	storage, err := sqlite.New(cfg.StoragePath)
+	// Settings are checked before the storage is opened, so that a bad one
+	// does not exit with the database still open.
+	if cfg.Storage.SweepInterval <= 0 {
+		log.Error("storage.sweep_interval must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.Storage.SweepGrace < 0 {
+		log.Error("storage.sweep_grace must not be negative")
+		os.Exit(1)
+	}
+
+	if cfg.Analytics.FlushInterval <= 0 {
+		log.Error("analytics.flush_interval must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.Analytics.BufferSize <= 0 {
+		log.Error("analytics.buffer_size must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.Analytics.BatchSize <= 0 {
+		log.Error("analytics.batch_size must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.Analytics.IPSalt == "" {
+		log.Warn("analytics.ip_salt is not set, client IP hashes are easy to reverse")
+	}
+
+	proxies, err := clientip.NewResolver(cfg.HTTPServer.TrustedProxies)
+	if err != nil {
+		log.Error("failed to parse http_server.trusted_proxies", sl.Err(err))
+		os.Exit(1)
+	}
+
+	if cfg.Alias.CaseInsensitive && (cfg.Alias.Generator == aliasSequential || cfg.Alias.Generator == aliasHashids) {
+		// Distinct numbers can encode to aliases that differ only in case,
+		// and these then collide.
+		log.Warn("alias.case_insensitive makes mixed-case generated aliases collide, consider the words generator", slog.String("generator", cfg.Alias.Generator))
+	}
+
+	if cfg.Alias.MaxAttempts < 1 {
+		log.Error("alias.max_attempts must be positive")
+		os.Exit(1)
+	}
+
+	rules := aliasrule.Rules{
+		MinLength: cfg.Alias.MinLength,
+		MaxLength: cfg.Alias.MaxLength,
+		Charset:   cfg.Alias.Charset,
+		Reserved:  append(cfg.Alias.Reserved, app.ReservedAliases...),
+		Denied:    cfg.Alias.Denied,
+	}
+
+	validate, err := aliasrule.NewValidator(rules)
+	if err != nil {
+		log.Error("failed to init alias rules", sl.Err(err))
+		os.Exit(1)
+	}
+
+	switch cfg.Redirect.DefaultStatus {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		log.Error("redirect.default_status must be one of 301, 302, 307 or 308")
+		os.Exit(1)
+	}
+
+	if cfg.Redirect.PendingStatus < 400 || cfg.Redirect.PendingStatus > 499 {
+		log.Error("redirect.pending_status must be a 4xx status")
+		os.Exit(1)
+	}
+
+	var countries redirect.CountryLookup
+	if cfg.GeoIP.DatabasePath != "" {
+		db, err := geoip.Open(cfg.GeoIP.DatabasePath)
+		if err != nil {
+			log.Error("failed to open geoip database", sl.Err(err))
+			os.Exit(1)
+		}
+		defer db.Close()
+		countries = db
+	}
+
+	storage, err := setupStorage(cfg)
 	if err != nil {
 		log.Error("failed to init storage", sl.Err(err))
 		os.Exit(1)
 	}
 
-	router := app.NewRouter(log, storage, cfg.HTTPServer.User, cfg.HTTPServer.Password)
+	aliasGenerator, err := setupAliasGenerator(cfg, storage)
+	if err != nil {
+		log.Error("failed to init alias generator", sl.Err(err))
+		storage.Close()
+		os.Exit(1)
+	}
+
+	aliases := random.AliasSource{
+		Generator:   aliasGenerator,
+		MaxAttempts: cfg.Alias.MaxAttempts,
+		GrowEvery:   cfg.Alias.GrowEvery,
+		Allowed:     rules.Allows,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		sweeper.Run(ctx, log, storage, cfg.Storage.SweepInterval, cfg.Storage.SweepGrace)
+	}()
+
+	clickRecorder := analytics.New(log, storage, analytics.Config{
+		BufferSize:    cfg.Analytics.BufferSize,
+		BatchSize:     cfg.Analytics.BatchSize,
+		FlushInterval: cfg.Analytics.FlushInterval,
+		IPSalt:        cfg.Analytics.IPSalt,
+		Proxies:       proxies,
+	})
+
+	router := app.NewRouter(log, storage, clickRecorder, aliases, validate, app.Config{
+		User:         cfg.HTTPServer.User,
+		Password:     cfg.HTTPServer.Password,
+		MaxBatchSize: cfg.HTTPServer.MaxBatchSize,
+		Deduplicate:  cfg.Alias.Deduplicate,
+		Redirect: redirect.Config{
+			DefaultStatus:        cfg.Redirect.DefaultStatus,
+			PermanentMaxAge:      cfg.Redirect.PermanentMaxAge,
+			PasswordAttempts:     cfg.Redirect.PasswordAttempts,
+			PasswordLinkAttempts: cfg.Redirect.PasswordLinkAttempts,
+			PasswordLockout:      cfg.Redirect.PasswordLockout,
+			PendingStatus:        cfg.Redirect.PendingStatus,
+			PendingMessage:       cfg.Redirect.PendingMessage,
+			Countries:            countries,
+			Proxies:              proxies,
+		},
+	})
+
+	// Timeout puts a deadline on the request context, so storage calls
+	// are cancelled together with the request.
+	handler := middleware.Timeout(cfg.HTTPServer.Timeout)(router)
 
 	log.Info("server started", slog.String("address", cfg.Address))
 
 	server := http.Server{
 		Addr:         cfg.Address,
-		Handler:      router,
+		Handler:      handler,
 		ReadTimeout:  cfg.HTTPServer.Timeout,
 		WriteTimeout: cfg.HTTPServer.Timeout,
 		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	// A server that fails to start still shuts down in order, so the
+	// storage is closed before exiting.
+	failed := false
+	select {
+	case <-done:
+		log.Info("stopping server")
+	case err := <-serverErr:
 		log.Error("failed to start server", sl.Err(err))
+		failed = true
+	}
+
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("failed to stop server", sl.Err(err))
+	}
+
+	clickRecorder.Close()
+
+	// A sweep still running could change the storage while it is closed.
+	<-sweeperDone
+
+	if err := storage.Close(); err != nil {
+		log.Error("failed to close storage", sl.Err(err))
+	}
+
+	log.Info("server stopped")
+
+	if failed {
 		os.Exit(1)
 	}
 }
@@ -62,3 +290,51 @@ func setupLogger(env string) *slog.Logger {
 
 	return log
 }
+
+func setupStorage(cfg *config.Config) (Storage, error) {
+	opts := storage.Options{CaseInsensitiveAliases: cfg.Alias.CaseInsensitive}
+
+	switch cfg.Storage.Driver {
+	case storageSQLite:
+		if cfg.StoragePath == "" {
+			return nil, fmt.Errorf("storage_path is required for the %s driver", storageSQLite)
+		}
+		return sqlite.New(cfg.StoragePath, opts)
+	case storagePostgres:
+		if cfg.Storage.DSN == "" {
+			return nil, fmt.Errorf("storage.dsn is required for the %s driver", storagePostgres)
+		}
+		return postgres.New(cfg.Storage.DSN, opts)
+	case storageMemory:
+		return memory.New(cfg.Storage.SnapshotPath, opts)
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %q", cfg.Storage.Driver)
+	}
+}
+
+func setupAliasGenerator(cfg *config.Config, storage Storage) (random.AliasGenerator, error) {
+	switch cfg.Alias.Generator {
+	case aliasRandom:
+		if cfg.Alias.Length < 1 {
+			return nil, fmt.Errorf("alias.length must be positive for the %s generator", aliasRandom)
+		}
+		return random.NewBase62Generator(cfg.Alias.Length), nil
+	case aliasWords:
+		if cfg.Alias.Words < 1 || cfg.Alias.Syllables < 1 {
+			return nil, fmt.Errorf("alias.words and alias.syllables must be positive for the %s generator", aliasWords)
+		}
+		return random.NewWordsGenerator(cfg.Alias.Words, cfg.Alias.Syllables), nil
+	case aliasSequential, aliasHashids:
+		// The numbers come from the database, so that replicas sharing it
+		// never hand out the same alias.
+		if cfg.Alias.Generator == aliasSequential {
+			return random.NewSequentialGenerator(storage.NextAliasNumber), nil
+		}
+		if cfg.Alias.Salt == "" {
+			return nil, fmt.Errorf("alias.salt is required for the %s generator", aliasHashids)
+		}
+		return random.NewHashidsGenerator(storage.NextAliasNumber, cfg.Alias.Salt, cfg.Alias.Length), nil
+	default:
+		return nil, fmt.Errorf("unknown alias generator: %q", cfg.Alias.Generator)
+	}
+}
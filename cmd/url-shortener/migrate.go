@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"urlShortener/internal/config"
+	"urlShortener/internal/storage/migrations"
+	"urlShortener/internal/storage/postgres"
+	"urlShortener/internal/storage/sqlite"
+)
+
+const migrateUsage = "usage: url-shortener migrate [up | down [steps] | status]"
+
+// runMigrate implements the "migrate" subcommand, which manages the schema
+// of the configured SQL storage outside the server process.
+func runMigrate(cfg *config.Config, log *slog.Logger, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	db, dialect, err := openMigrationDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrations.New(db, dialect)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "up":
+		applied, err := m.Up()
+		if err != nil {
+			return err
+		}
+		log.Info("migrations applied", slog.Int("count", applied), slog.Int("version", m.Latest()))
+		if err := m.KeyAliases(cfg.Alias.CaseInsensitive); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %q", args[1])
+			}
+		}
+		reverted, err := m.Down(steps)
+		if err != nil {
+			return err
+		}
+		version, err := m.Version()
+		if err != nil {
+			return err
+		}
+		log.Info("migrations reverted", slog.Int("count", reverted), slog.Int("version", version))
+	case "status":
+		version, err := m.Version()
+		if err != nil {
+			return err
+		}
+		log.Info("migration status", slog.Int("version", version), slog.Int("latest", m.Latest()))
+	default:
+		return fmt.Errorf("unknown migrate command %q, %s", cmd, migrateUsage)
+	}
+
+	return nil
+}
+
+func openMigrationDB(cfg *config.Config) (*sql.DB, migrations.Dialect, error) {
+	switch cfg.Storage.Driver {
+	case storageSQLite:
+		if cfg.StoragePath == "" {
+			return nil, migrations.Dialect{}, fmt.Errorf("storage_path is required for the %s driver", storageSQLite)
+		}
+		db, err := sqlite.Open(cfg.StoragePath)
+		return db, migrations.SQLite, err
+	case storagePostgres:
+		if cfg.Storage.DSN == "" {
+			return nil, migrations.Dialect{}, fmt.Errorf("storage.dsn is required for the %s driver", storagePostgres)
+		}
+		db, err := postgres.Open(cfg.Storage.DSN)
+		return db, migrations.Postgres, err
+	default:
+		return nil, migrations.Dialect{}, fmt.Errorf("storage driver %q has no migrations", cfg.Storage.Driver)
+	}
+}
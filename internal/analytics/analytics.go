@@ -0,0 +1,167 @@
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"urlShortener/internal/lib/clientip"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/storage"
+)
+
+// flushTimeout bounds a single batch write so a stuck database can't wedge the recorder.
+const flushTimeout = 5 * time.Second
+
+type ClickSaver interface {
+	SaveClicks(ctx context.Context, clicks []storage.Click) error
+}
+
+type Config struct {
+	// BufferSize is how many clicks may wait for a write before new ones are dropped.
+	BufferSize int
+	// BatchSize is the largest number of clicks written at once.
+	BatchSize int
+	// FlushInterval is how long a partial batch may wait before being written.
+	FlushInterval time.Duration
+	// IPSalt keys the client IP hash.
+	IPSalt string
+	// Proxies decides whose X-Forwarded-For is believed when telling
+	// clients apart. Nil trusts nobody.
+	Proxies *clientip.Resolver
+}
+
+// Recorder collects click events off the request path and writes them to
+// storage in batches from a background goroutine.
+type Recorder struct {
+	log    *slog.Logger
+	saver  ClickSaver
+	cfg    Config
+	clicks chan storage.Click
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New creates a Recorder and starts its background writer. Call Close to
+// flush pending clicks and stop it.
+func New(log *slog.Logger, saver ClickSaver, cfg Config) *Recorder {
+	r := &Recorder{
+		log:    log.With(slog.String("component", "analytics")),
+		saver:  saver,
+		cfg:    cfg,
+		clicks: make(chan storage.Click, cfg.BufferSize),
+		done:   make(chan struct{}),
+	}
+
+	go r.run()
+
+	return r
+}
+
+// Record queues a click on alias made by req. It never blocks: if the
+// buffer is full the click is dropped.
+func (r *Recorder) Record(alias string, req *http.Request) {
+	click := storage.Click{
+		Alias:     alias,
+		ClickedAt: time.Now().UTC(),
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		IPHash:    r.hashIP(r.clientIP(req)),
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.clicks <- click:
+	default:
+		r.log.Warn("click buffer is full, dropping click", slog.String("alias", alias))
+	}
+}
+
+// Close stops accepting clicks and waits until everything queued is written.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.clicks)
+	r.mu.Unlock()
+
+	<-r.done
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.Click, 0, r.cfg.BatchSize)
+
+	for {
+		select {
+		case click, ok := <-r.clicks:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+
+			batch = append(batch, click)
+			if len(batch) >= r.cfg.BatchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			r.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (r *Recorder) flush(batch []storage.Click) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := r.saver.SaveClicks(ctx, batch); err != nil {
+		r.log.Error("failed to save clicks", sl.Err(err), slog.Int("count", len(batch)))
+	}
+}
+
+// clientIP returns the address of the client behind req, or "" if unknown.
+func (r *Recorder) clientIP(req *http.Request) string {
+	addr := r.cfg.Proxies.Addr(req)
+	if !addr.IsValid() {
+		return ""
+	}
+
+	return addr.String()
+}
+
+func (r *Recorder) hashIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(r.cfg.IPSalt))
+	mac.Write([]byte(ip))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,153 @@
+package analytics
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"urlShortener/internal/lib/clientip"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSaver struct {
+	mu      sync.Mutex
+	batches [][]storage.Click
+}
+
+func (f *fakeSaver) SaveClicks(_ context.Context, clicks []storage.Click) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches = append(f.batches, append([]storage.Click(nil), clicks...))
+
+	return nil
+}
+
+func (f *fakeSaver) saved() []storage.Click {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var all []storage.Click
+	for _, b := range f.batches {
+		all = append(all, b...)
+	}
+
+	return all
+}
+
+func TestRecorder_Record(t *testing.T) {
+	saver := &fakeSaver{}
+	r := New(slogdiscard.NewDiscardLogger(), saver, Config{
+		BufferSize:    10,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		IPSalt:        "salt",
+	})
+
+	req := httptest.NewRequest("GET", "/google", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set("Referer", "https://news.example.com")
+	req.Header.Set("User-Agent", "test-agent")
+
+	r.Record("google", req)
+	r.Close()
+
+	clicks := saver.saved()
+	require.Len(t, clicks, 1)
+
+	c := clicks[0]
+	assert.Equal(t, "google", c.Alias)
+	assert.Equal(t, "https://news.example.com", c.Referrer)
+	assert.Equal(t, "test-agent", c.UserAgent)
+	assert.WithinDuration(t, time.Now(), c.ClickedAt, time.Minute)
+	assert.NotEmpty(t, c.IPHash)
+	assert.NotContains(t, c.IPHash, "203.0.113.7")
+
+	// The same IP always hashes to the same value so unique visitors can be counted.
+	assert.Equal(t, r.hashIP("203.0.113.7"), c.IPHash)
+	assert.NotEqual(t, r.hashIP("203.0.113.8"), c.IPHash)
+}
+
+func TestRecorder_Record_Proxies(t *testing.T) {
+	proxies, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	saver := &fakeSaver{}
+	r := New(slogdiscard.NewDiscardLogger(), saver, Config{
+		BufferSize:    10,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		IPSalt:        "salt",
+		Proxies:       proxies,
+	})
+
+	for _, client := range []string{"203.0.113.7", "203.0.113.8"} {
+		req := httptest.NewRequest("GET", "/google", nil)
+		req.RemoteAddr = "10.0.0.1:51234"
+		req.Header.Set("X-Forwarded-For", client)
+		r.Record("google", req)
+	}
+	r.Close()
+
+	clicks := saver.saved()
+	require.Len(t, clicks, 2)
+
+	// Visitors behind the same proxy are still told apart.
+	assert.Equal(t, r.hashIP("203.0.113.7"), clicks[0].IPHash)
+	assert.Equal(t, r.hashIP("203.0.113.8"), clicks[1].IPHash)
+}
+
+func TestRecorder_BatchSize(t *testing.T) {
+	saver := &fakeSaver{}
+	r := New(slogdiscard.NewDiscardLogger(), saver, Config{
+		BufferSize:    100,
+		BatchSize:     5,
+		FlushInterval: time.Hour,
+	})
+
+	for i := 0; i < 12; i++ {
+		r.Record("google", httptest.NewRequest("GET", "/google", nil))
+	}
+	r.Close()
+
+	require.Len(t, saver.saved(), 12)
+
+	for _, b := range saver.batches {
+		assert.LessOrEqual(t, len(b), 5)
+	}
+}
+
+func TestRecorder_FlushInterval(t *testing.T) {
+	saver := &fakeSaver{}
+	r := New(slogdiscard.NewDiscardLogger(), saver, Config{
+		BufferSize:    10,
+		BatchSize:     100,
+		FlushInterval: 10 * time.Millisecond,
+	})
+	defer r.Close()
+
+	r.Record("google", httptest.NewRequest("GET", "/google", nil))
+
+	assert.Eventually(t, func() bool { return len(saver.saved()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestRecorder_RecordAfterClose(t *testing.T) {
+	saver := &fakeSaver{}
+	r := New(slogdiscard.NewDiscardLogger(), saver, Config{
+		BufferSize:    10,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+	})
+
+	r.Close()
+	r.Record("google", httptest.NewRequest("GET", "/google", nil))
+	r.Close()
+
+	assert.Empty(t, saver.saved())
+}
@@ -1,28 +1,70 @@
 package app
 
 import (
+	"context"
+	"expvar"
 	"log/slog"
 
 	"urlShortener/internal/http-server/handlers/redirect"
+	"urlShortener/internal/http-server/handlers/url/batchdelete"
+	"urlShortener/internal/http-server/handlers/url/batchsave"
 	"urlShortener/internal/http-server/handlers/url/delete"
+	"urlShortener/internal/http-server/handlers/url/get"
+	"urlShortener/internal/http-server/handlers/url/list"
 	"urlShortener/internal/http-server/handlers/url/save"
+	"urlShortener/internal/http-server/handlers/url/stats"
+	"urlShortener/internal/http-server/handlers/url/update"
+	"urlShortener/internal/http-server/handlers/url/utmtemplate"
 	"urlShortener/internal/http-server/middleware/logger"
+	"urlShortener/internal/lib/random"
+	"urlShortener/internal/storage"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-playground/validator/v10"
 )
 
 // Storage defines the interface for URL storage operations.
 // This allows using different storage implementations (sqlite, postgres, etc.)
 type Storage interface {
-	SaveURL(urlToSave string, alias string) (int64, error)
-	GetURL(alias string) (string, error)
-	DeleteURL(alias string) error
+	SaveURL(ctx context.Context, urlToSave string, alias string, opts storage.URLOptions) (int64, error)
+	SaveURLs(ctx context.Context, urls []storage.NewURL, atomic bool) ([]storage.SaveResult, error)
+	GetURL(ctx context.Context, alias string) (storage.URL, error)
+	ConsumeClick(ctx context.Context, alias string) error
+	GetURLInfo(ctx context.Context, alias string) (storage.URL, error)
+	GetURLByDestination(ctx context.Context, urlToSave string) (storage.URL, error)
+	UpdateURL(ctx context.Context, alias string, newURL string, version int64) (int64, error)
+	DeleteURL(ctx context.Context, alias string) error
+	DeleteURLs(ctx context.Context, q storage.DeleteQuery) (storage.DeleteResult, error)
+	ListURLs(ctx context.Context, q storage.ListQuery) ([]storage.URL, error)
+	ClickStats(ctx context.Context, alias string, q storage.StatsQuery) (storage.ClickStats, error)
+	SaveUTMTemplate(ctx context.Context, t storage.UTMTemplate) error
+	GetUTMTemplate(ctx context.Context, name string) (storage.UTMTemplate, error)
+	ListUTMTemplates(ctx context.Context) ([]storage.UTMTemplate, error)
+	DeleteUTMTemplate(ctx context.Context, name string) error
+}
+
+// ReservedAliases are the path segments taken by routes other than the
+// redirect and by /url routes other than link info, so links cannot use
+// them as aliases.
+var ReservedAliases = []string{"url", "debug", "utm-templates"}
+
+// Config holds the settings the routes are built with.
+type Config struct {
+	// User and Password guard the /url API with basic auth.
+	User     string
+	Password string
+	// MaxBatchSize caps the number of items in one batch request.
+	MaxBatchSize int
+	// Deduplicate reuses existing links to the same destination by default.
+	Deduplicate bool
+	// Redirect holds the settings of the public redirect route.
+	Redirect redirect.Config
 }
 
 // NewRouter creates and configures a chi router with all application routes.
 // It accepts dependencies that can be swapped for testing.
-func NewRouter(log *slog.Logger, storage Storage, user, password string) chi.Router {
+func NewRouter(log *slog.Logger, storage Storage, clickRecorder redirect.ClickRecorder, aliases random.AliasSource, validate *validator.Validate, cfg Config) chi.Router {
 	router := chi.NewRouter()
 
 	router.Use(middleware.RequestID)
@@ -30,16 +72,37 @@ func NewRouter(log *slog.Logger, storage Storage, user, password string) chi.Rou
 	router.Use(middleware.URLFormat)
 	router.Use(logger.New(log))
 
+	auth := middleware.BasicAuth("url-shortener", map[string]string{
+		cfg.User: cfg.Password,
+	})
+
+	router.With(auth).Get("/debug/vars", expvar.Handler().ServeHTTP)
+
 	router.Route("/url", func(r chi.Router) {
-		r.Use(middleware.BasicAuth("url-shortener", map[string]string{
-			user: password,
-		}))
+		r.Use(auth)
 
-		r.Post("/", save.New(log, storage))
+		r.Get("/", list.New(log, storage))
+		r.Post("/", save.New(log, storage, aliases, validate, cfg.Deduplicate))
+		r.Post("/batch", batchsave.New(log, storage, aliases, validate, cfg.MaxBatchSize))
+		r.Post("/batch/delete", batchdelete.New(log, storage, cfg.MaxBatchSize))
+		r.Get("/{alias}", get.New(log, storage))
+		r.Patch("/{alias}", update.New(log, storage))
 		r.Delete("/{alias}", delete.New(log, storage))
+		r.Get("/{alias}/stats", stats.New(log, storage))
+
+		r.Get("/utm-templates", utmtemplate.NewList(log, storage))
+		r.Get("/utm-templates/{name}", utmtemplate.NewGet(log, storage))
+		r.Put("/utm-templates/{name}", utmtemplate.NewPut(log, storage, validate))
+		r.Delete("/utm-templates/{name}", utmtemplate.NewDelete(log, storage))
 	})
 
-	router.Get("/{alias}", redirect.New(log, storage))
+	// The wildcard routes serve passthrough links, which pass trailing path
+	// segments on. POST carries the password form of protected links.
+	redirectHandler := redirect.New(log, storage, clickRecorder, cfg.Redirect)
+	router.Get("/{alias}", redirectHandler)
+	router.Get("/{alias}/*", redirectHandler)
+	router.Post("/{alias}", redirectHandler)
+	router.Post("/{alias}/*", redirectHandler)
 
 	return router
 }
@@ -9,9 +9,89 @@ import (
 )
 
 type Config struct {
-	Env         string `yaml:"env" env-default:"local"`
-	StoragePath string `yaml:"storage_path" env-required:"true"`
+	Env         string  `yaml:"env" env-default:"local"`
+	StoragePath string  `yaml:"storage_path"`
+	Storage     Storage `yaml:"storage"`
 	HTTPServer  `yaml:"http_server"`
+	Analytics   Analytics `yaml:"analytics"`
+	Alias       Alias     `yaml:"alias"`
+	Redirect    Redirect  `yaml:"redirect"`
+	GeoIP       GeoIP     `yaml:"geoip"`
+}
+
+type Storage struct {
+	// Driver is sqlite, postgres (version 12 or later) or memory.
+	Driver       string `yaml:"driver" env-default:"sqlite"`
+	DSN          string `yaml:"dsn" env:"STORAGE_DSN"`
+	SnapshotPath string `yaml:"snapshot_path"`
+	// SweepInterval is how often expired links are purged. Expired links
+	// answer 410 Gone for SweepGrace, and 404 Not Found once purged.
+	SweepInterval time.Duration `yaml:"sweep_interval" env-default:"1m"`
+	SweepGrace    time.Duration `yaml:"sweep_grace" env-default:"24h"`
+}
+
+type Analytics struct {
+	BufferSize    int           `yaml:"buffer_size" env-default:"10000"`
+	BatchSize     int           `yaml:"batch_size" env-default:"100"`
+	FlushInterval time.Duration `yaml:"flush_interval" env-default:"1s"`
+	IPSalt        string        `yaml:"ip_salt" env:"ANALYTICS_IP_SALT"`
+}
+
+// Alias selects how aliases are made up for links created without one.
+type Alias struct {
+	// Generator is one of random, sequential, hashids or words.
+	Generator string `yaml:"generator" env-default:"random"`
+	// Length is the alias length for random and the minimum for hashids.
+	Length int `yaml:"length" env-default:"6"`
+	// Salt scrambles hashids aliases; keep it secret and never change it.
+	Salt string `yaml:"salt" env:"ALIAS_SALT"`
+	// Words and Syllables shape words aliases, e.g. 2 and 3 give "bakoti-muresa".
+	Words     int `yaml:"words" env-default:"2"`
+	Syllables int `yaml:"syllables" env-default:"3"`
+	// MaxAttempts bounds how many generated aliases are tried for one link.
+	MaxAttempts int `yaml:"max_attempts" env-default:"5"`
+	// GrowEvery lengthens generated aliases after every GrowEvery collisions.
+	GrowEvery int `yaml:"grow_every" env-default:"2"`
+	// MinLength, MaxLength and Charset restrict the aliases clients choose.
+	// Charset is the body of a regular expression character class.
+	MinLength int    `yaml:"min_length" env-default:"1"`
+	MaxLength int    `yaml:"max_length" env-default:"64"`
+	Charset   string `yaml:"charset" env-default:"a-zA-Z0-9_-"`
+	// Reserved aliases cannot be chosen; Denied words cannot appear in them.
+	Reserved []string `yaml:"reserved"`
+	Denied   []string `yaml:"denied"`
+	// Deduplicate makes POST /url without an alias return an existing link
+	// to the same destination. Requests can override it.
+	Deduplicate bool `yaml:"deduplicate"`
+	// CaseInsensitive makes "MyLink" and "mylink" the same alias. Aliases
+	// are stored as given, so it can be turned off again; turning it on
+	// fails if two of them differ only in case.
+	CaseInsensitive bool `yaml:"case_insensitive"`
+}
+
+// Redirect configures the public redirect route.
+type Redirect struct {
+	// DefaultStatus is one of 301, 302, 307 or 308. Links can override it.
+	DefaultStatus int `yaml:"default_status" env-default:"302"`
+	// PermanentMaxAge is how long clients may cache 301 and 308 redirects.
+	PermanentMaxAge time.Duration `yaml:"permanent_max_age" env-default:"24h"`
+	// PasswordAttempts wrong passwords per client and link lock the client
+	// out for PasswordLockout, and PasswordLinkAttempts per link from all
+	// clients together lock the link. Zero disables a limit.
+	PasswordAttempts     int           `yaml:"password_attempts" env-default:"5"`
+	PasswordLinkAttempts int           `yaml:"password_link_attempts" env-default:"100"`
+	PasswordLockout      time.Duration `yaml:"password_lockout" env-default:"15m"`
+	// PendingStatus and PendingMessage answer visits to links before their
+	// not_before time that have no fallback URL. The default looks just
+	// like an unknown alias, so links can't be discovered early.
+	PendingStatus  int    `yaml:"pending_status" env-default:"404"`
+	PendingMessage string `yaml:"pending_message" env-default:"not found"`
+}
+
+// GeoIP points at a local MaxMind DB country database, such as
+// GeoLite2-Country.mmdb. Without one, country targets are ignored.
+type GeoIP struct {
+	DatabasePath string `yaml:"database_path" env:"GEOIP_DATABASE_PATH"`
 }
 
 type HTTPServer struct {
@@ -20,6 +100,11 @@ type HTTPServer struct {
 	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s"`
 	User        string        `yaml:"user" env-required:"true"`
 	Password    string        `yaml:"password" env-required:"true" env:"HTTP_SERVER_PASSWORD"`
+	// MaxBatchSize caps the number of links in one batch save or delete request.
+	MaxBatchSize int `yaml:"max_batch_size" env-default:"1000"`
+	// TrustedProxies are the addresses or networks, e.g. "10.0.0.0/8",
+	// whose X-Forwarded-For is believed when locating clients.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 func MustLoad() *Config {
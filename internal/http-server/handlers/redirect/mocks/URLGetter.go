@@ -2,34 +2,86 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+
+	storage "urlShortener/internal/storage"
+)
 
 // URLGetter is an autogenerated mock type for the URLGetter type
 type URLGetter struct {
 	mock.Mock
 }
 
-// GetURL provides a mock function with given fields: alias
-func (_m *URLGetter) GetURL(alias string) (string, error) {
-	ret := _m.Called(alias)
+// ConsumeClick provides a mock function with given fields: ctx, alias
+func (_m *URLGetter) ConsumeClick(ctx context.Context, alias string) error {
+	ret := _m.Called(ctx, alias)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ConsumeClick")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, alias)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetURL provides a mock function with given fields: ctx, alias
+func (_m *URLGetter) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	ret := _m.Called(ctx, alias)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetURL")
 	}
 
-	var r0 string
+	var r0 storage.URL
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (storage.URL, error)); ok {
+		return rf(ctx, alias)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.URL); ok {
+		r0 = rf(ctx, alias)
+	} else {
+		r0 = ret.Get(0).(storage.URL)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, alias)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUTMTemplate provides a mock function with given fields: ctx, name
+func (_m *URLGetter) GetUTMTemplate(ctx context.Context, name string) (storage.UTMTemplate, error) {
+	ret := _m.Called(ctx, name)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUTMTemplate")
+	}
+
+	var r0 storage.UTMTemplate
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string) (string, error)); ok {
-		return rf(alias)
+	if rf, ok := ret.Get(0).(func(context.Context, string) (storage.UTMTemplate, error)); ok {
+		return rf(ctx, name)
 	}
-	if rf, ok := ret.Get(0).(func(string) string); ok {
-		r0 = rf(alias)
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.UTMTemplate); ok {
+		r0 = rf(ctx, name)
 	} else {
-		r0 = ret.Get(0).(string)
+		r0 = ret.Get(0).(storage.UTMTemplate)
 	}
 
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(alias)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,92 @@
+package redirect
+
+import (
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"urlShortener/internal/lib/clientip"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/lib/password"
+	"urlShortener/internal/lib/throttle"
+)
+
+// maxFormSize bounds the body of a password form submission.
+const maxFormSize = 4 << 10
+
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+<p>This link is password protected.</p>
+{{if .}}<p role="alert">{{.}}</p>
+{{end}}<input type="password" name="password" aria-label="Password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// passwordAttempts throttles wrong passwords per client and link, and per
+// link across all clients, so that clients rotating their address do not
+// get unlimited guesses either.
+type passwordAttempts struct {
+	clients *throttle.Limiter
+	links   *throttle.Limiter
+	// proxies resolves the client address the clients limit goes by.
+	proxies *clientip.Resolver
+}
+
+// unlocked reports whether r carries the password matching hash. If not, it
+// answers with the password form and the visitor stays on the short link.
+// Wrong guesses count against the client and the link in attempts.
+func unlocked(w http.ResponseWriter, r *http.Request, log *slog.Logger, attempts passwordAttempts, alias, hash string) bool {
+	if r.Method != http.MethodPost {
+		showForm(w, log, http.StatusOK, "")
+		return false
+	}
+
+	client := alias + "|" + attempts.proxies.Addr(r).String()
+
+	ok, retryAfter := attempts.clients.Allow(client)
+	if ok {
+		ok, retryAfter = attempts.links.Allow(alias)
+	}
+	if !ok {
+		log.Warn("password attempts throttled", slog.String("alias", alias))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		showForm(w, log, http.StatusTooManyRequests, "Too many attempts, try again later.")
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	if !password.Check(hash, r.PostFormValue("password")) {
+		log.Info("wrong password", slog.String("alias", alias))
+		attempts.clients.Fail(client)
+		attempts.links.Fail(alias)
+		showForm(w, log, http.StatusForbidden, "Wrong password.")
+		return false
+	}
+
+	// The link's count is left to run out, so that knowing the password
+	// does not buy other clients more guesses.
+	attempts.clients.Reset(client)
+
+	return true
+}
+
+func showForm(w http.ResponseWriter, log *slog.Logger, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := passwordForm.Execute(w, message); err != nil {
+		log.Error("failed to render password form", sl.Err(err))
+	}
+}
@@ -0,0 +1,166 @@
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"urlShortener/internal/http-server/handlers/redirect/mocks"
+	"urlShortener/internal/lib/clientip"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRedirectHandler_Password(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	mockGetter := mocks.NewURLGetter(t)
+	mockGetter.On("GetURL", mock.Anything, "docs").Return(storage.URL{
+		URL: "https://docs.example.com",
+		URLOptions: storage.URLOptions{
+			RedirectStatus: http.StatusPermanentRedirect,
+			PasswordHash:   string(hash),
+		},
+	}, nil)
+
+	mockRecorder := mocks.NewClickRecorder(t)
+	mockRecorder.On("Record", "docs", mock.Anything).Once()
+
+	cfg := testConfig
+	cfg.PasswordAttempts = 2
+	cfg.PasswordLockout = time.Minute
+
+	handler := New(slogdiscard.NewDiscardLogger(), mockGetter, mockRecorder, cfg)
+
+	r := chi.NewRouter()
+	r.Get("/{alias}", handler)
+	r.Post("/{alias}", handler)
+
+	submit := func(password, remoteAddr string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A visit shows the form instead of redirecting.
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Body.String(), `<form method="post">`)
+	assert.Contains(t, rec.Body.String(), `name="password"`)
+
+	rec = submit("wrong", "10.0.0.1:1234")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Wrong password.")
+
+	rec = submit("wrong", "10.0.0.1:1234")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Out of attempts, even the right password is turned away.
+	rec = submit("s3cret", "10.0.0.1:1234")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// Other clients are not affected.
+	rec = submit("s3cret", "10.0.0.2:1234")
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "https://docs.example.com", rec.Header().Get("Location"))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+}
+
+func TestRedirectHandler_PasswordBehindProxy(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	mockGetter := mocks.NewURLGetter(t)
+	mockGetter.On("GetURL", mock.Anything, "docs").Return(storage.URL{
+		URL:        "https://docs.example.com",
+		URLOptions: storage.URLOptions{PasswordHash: string(hash)},
+	}, nil)
+
+	mockRecorder := mocks.NewClickRecorder(t)
+	mockRecorder.On("Record", "docs", mock.Anything).Once()
+
+	proxies, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	cfg := testConfig
+	cfg.PasswordAttempts = 1
+	cfg.PasswordLockout = time.Minute
+	cfg.Proxies = proxies
+
+	r := chi.NewRouter()
+	r.Post("/{alias}", New(slogdiscard.NewDiscardLogger(), mockGetter, mockRecorder, cfg))
+
+	submit := func(password, client string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", client)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusForbidden, submit("wrong", "203.0.113.7").Code)
+	require.Equal(t, http.StatusTooManyRequests, submit("s3cret", "203.0.113.7").Code)
+
+	// Clients behind the same proxy are throttled on their own.
+	require.Equal(t, http.StatusSeeOther, submit("s3cret", "203.0.113.8").Code)
+}
+
+func TestRedirectHandler_PasswordLinkLimit(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	mockGetter := mocks.NewURLGetter(t)
+	mockGetter.On("GetURL", mock.Anything, "docs").Return(storage.URL{
+		URL:        "https://docs.example.com",
+		URLOptions: storage.URLOptions{PasswordHash: string(hash)},
+	}, nil)
+
+	cfg := testConfig
+	cfg.PasswordAttempts = 2
+	cfg.PasswordLinkAttempts = 3
+	cfg.PasswordLockout = time.Minute
+
+	r := chi.NewRouter()
+	r.Post("/{alias}", New(slogdiscard.NewDiscardLogger(), mockGetter, mocks.NewClickRecorder(t), cfg))
+
+	submit := func(password, remoteAddr string) int {
+		form := url.Values{"password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Every guess comes from a new address, so only the link's limit
+	// stops them.
+	for i := range 3 {
+		require.Equal(t, http.StatusForbidden, submit("wrong", fmt.Sprintf("10.0.0.%d:1234", i+1)))
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, submit("wrong", "10.0.0.9:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, submit("s3cret", "10.0.0.10:1234"))
+}
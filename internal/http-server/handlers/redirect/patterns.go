@@ -0,0 +1,44 @@
+package redirect
+
+import (
+	"regexp"
+	"sync"
+)
+
+// maxPatterns bounds how many compiled target patterns are kept. Once it is
+// reached the cache starts over rather than growing with every link.
+const maxPatterns = 1024
+
+// patterns compiles regex target patterns once and keeps them for later
+// redirects.
+type patterns struct {
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp
+}
+
+func newPatterns() *patterns {
+	return &patterns{compiled: make(map[string]*regexp.Regexp)}
+}
+
+// get returns the compiled pattern. Patterns are checked when the link is
+// saved, so an error means the stored link predates that check.
+func (p *patterns) get(pattern string) (*regexp.Regexp, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if re, ok := p.compiled[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.compiled) >= maxPatterns {
+		clear(p.compiled)
+	}
+	p.compiled[pattern] = re
+
+	return re, nil
+}
@@ -1,11 +1,21 @@
 package redirect
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/clientip"
 	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/lib/throttle"
+	"urlShortener/internal/lib/useragent"
 	"urlShortener/internal/storage"
 
 	"github.com/go-chi/chi/v5"
@@ -14,14 +24,69 @@ import (
 )
 
 type URLGetter interface {
-	GetURL(alias string) (string, error)
+	GetURL(ctx context.Context, alias string) (storage.URL, error)
+	GetUTMTemplate(ctx context.Context, name string) (storage.UTMTemplate, error)
+	ConsumeClick(ctx context.Context, alias string) error
 }
 
-func New(log *slog.Logger, urlGetter URLGetter) http.HandlerFunc {
+type ClickRecorder interface {
+	Record(alias string, r *http.Request)
+}
+
+// CountryLookup returns the ISO 3166-1 alpha-2 code of the country addr is
+// in, or "" if it is unknown.
+type CountryLookup interface {
+	Country(addr netip.Addr) (string, error)
+}
+
+// Config holds the server-wide redirect settings.
+type Config struct {
+	// DefaultStatus is used for links that do not choose their own status.
+	DefaultStatus int
+	// PermanentMaxAge is how long clients may cache 301 and 308 redirects.
+	PermanentMaxAge time.Duration
+	// PasswordAttempts is how many wrong passwords a client may enter for
+	// a link within PasswordLockout before it has to wait, and
+	// PasswordLinkAttempts how many all clients together may enter before
+	// the link stops taking passwords for that long. Zero means no limit.
+	PasswordAttempts     int
+	PasswordLinkAttempts int
+	PasswordLockout      time.Duration
+	// PendingStatus and PendingMessage answer visits to links that are not
+	// active yet and have no fallback URL.
+	PendingStatus  int
+	PendingMessage string
+	// Countries places clients for links with country targets. Nil turns
+	// country targeting off, so those links go to their own URL.
+	Countries CountryLookup
+	// Proxies decides whose X-Forwarded-For is believed about the client
+	// address, which country targets and password attempts go by. Nil
+	// trusts nobody.
+	Proxies *clientip.Resolver
+}
+
+// New redirects to the destination of the alias in the path. It serves
+// both /{alias} and /{alias}/*; the longer form only resolves for links
+// with passthrough on.
+//
+// Links with targets send matching clients to the target's URL instead of
+// their own; platform targets are tried before country targets. Links
+// before their activation time redirect to their fallback URL, or get cfg's
+// pending response. Links with a password get an HTML form instead, which
+// posts back to the same URL; the redirect follows the right password with
+// 303 See Other.
+func New(log *slog.Logger, urlGetter URLGetter, clickRecorder ClickRecorder, cfg Config) http.HandlerFunc {
+	attempts := passwordAttempts{
+		clients: throttle.New(cfg.PasswordAttempts, cfg.PasswordLockout),
+		links:   throttle.New(cfg.PasswordLinkAttempts, cfg.PasswordLockout),
+		proxies: cfg.Proxies,
+	}
+	patterns := newPatterns()
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.redirect.New"
 
-		log = log.With(slog.String("op", op),
+		log := log.With(slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())))
 
 		alias := chi.URLParam(r, "alias")
@@ -32,7 +97,7 @@ func New(log *slog.Logger, urlGetter URLGetter) http.HandlerFunc {
 			return
 		}
 
-		resURL, err := urlGetter.GetURL(alias)
+		link, err := urlGetter.GetURL(r.Context(), alias)
 		if errors.Is(err, storage.ErrURLNotFound) {
 			log.Info("url not found", "alias", alias)
 			render.Status(r, http.StatusNotFound)
@@ -40,6 +105,18 @@ func New(log *slog.Logger, urlGetter URLGetter) http.HandlerFunc {
 			return
 		}
 
+		if errors.Is(err, storage.ErrURLExpired) {
+			log.Info("url expired", "alias", alias)
+			render.Status(r, http.StatusGone)
+			render.JSON(w, r, resp.Error("url expired"))
+			return
+		}
+
+		if errors.Is(err, storage.ErrURLExhausted) {
+			exhausted(w, r, log, alias)
+			return
+		}
+
 		if err != nil {
 			log.Error("failed to get url", sl.Err(err))
 			render.Status(r, http.StatusInternalServerError)
@@ -47,8 +124,231 @@ func New(log *slog.Logger, urlGetter URLGetter) http.HandlerFunc {
 			return
 		}
 
-		log.Info("got url", slog.String("url", resURL))
+		log.Info("got url", slog.String("url", link.URL))
+
+		if link.Pending(time.Now()) {
+			pending(w, r, log, link, cfg)
+			return
+		}
+
+		rest := subpath(r)
+		if rest != "" && !link.Passthrough {
+			log.Info("subpath on link without passthrough", "alias", alias)
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("not found"))
+			return
+		}
+
+		protected := link.PasswordHash != ""
+		if protected && !unlocked(w, r, log, attempts, alias, link.PasswordHash) {
+			return
+		}
+
+		utm := link.UTM
+		if link.UTMTemplate != "" {
+			// A missing template must not break the link, so fall back to
+			// the link's own parameters.
+			template, err := urlGetter.GetUTMTemplate(r.Context(), link.UTMTemplate)
+			if err != nil {
+				log.Warn("failed to get utm template", slog.String("template", link.UTMTemplate), sl.Err(err))
+			}
+			utm = template.UTM.Merge(link.UTM)
+		}
+
+		targeted := false
+		if len(link.Targets) > 0 {
+			w.Header().Add("Vary", "User-Agent")
+			if u := target(patterns, link.Targets, r.UserAgent()); u != "" {
+				link.URL = u
+				targeted = true
+			}
+		}
+
+		if !targeted && len(link.CountryTargets) > 0 && cfg.Countries != nil {
+			if u := countryTarget(log, link.CountryTargets, cfg.Countries, cfg.Proxies.Addr(r)); u != "" {
+				link.URL = u
+			}
+		}
+
+		dest, err := destination(link, utm, rest, r.URL.Query())
+		if err != nil {
+			log.Error("failed to build destination", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		if link.MaxClicks > 0 {
+			// GetURL saw clicks left, but concurrent visits may have taken them.
+			err := urlGetter.ConsumeClick(r.Context(), alias)
+			if errors.Is(err, storage.ErrURLExhausted) {
+				exhausted(w, r, log, alias)
+				return
+			}
+			if err != nil {
+				log.Error("failed to consume click", sl.Err(err))
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, resp.Error("internal error"))
+				return
+			}
+		}
+
+		clickRecorder.Record(alias, r)
+
+		status := link.RedirectStatus
+		if status == 0 {
+			status = cfg.DefaultStatus
+		}
+
+		switch {
+		case protected:
+			// Neither the browser nor a proxy may remember the way past the form.
+			status = http.StatusSeeOther
+			w.Header().Set("Cache-Control", "no-store")
+		case link.MaxClicks > 0:
+			// Every visit has to reach ConsumeClick to be counted.
+			w.Header().Set("Cache-Control", "no-store")
+		case status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect:
+			// With country targets the destination depends on where the
+			// client is, which shared caches cannot tell apart.
+			scope := "public"
+			if len(link.CountryTargets) > 0 && cfg.Countries != nil {
+				scope = "private"
+			}
+			w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, maxAge(link, cfg.PermanentMaxAge)))
+		}
+
+		http.Redirect(w, r, dest, status)
+	}
+}
+
+// pending answers a visit to link before its activation time. Nothing about
+// the link itself may be revealed, and nothing may be cached past the launch.
+func pending(w http.ResponseWriter, r *http.Request, log *slog.Logger, link storage.URL, cfg Config) {
+	log.Info("url not active yet", "alias", link.Alias)
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if link.FallbackURL != "" {
+		http.Redirect(w, r, link.FallbackURL, http.StatusFound)
+		return
+	}
+
+	render.Status(r, cfg.PendingStatus)
+	render.JSON(w, r, resp.Error(cfg.PendingMessage))
+}
+
+func exhausted(w http.ResponseWriter, r *http.Request, log *slog.Logger, alias string) {
+	log.Info("url click limit reached", "alias", alias)
+	render.Status(r, http.StatusGone)
+	render.JSON(w, r, resp.Error("url click limit reached"))
+}
+
+// target returns the URL of the first of targets matching the client with
+// User-Agent ua, or "" if none does.
+func target(patterns *patterns, targets []storage.Target, ua string) string {
+	platform := useragent.Platform(ua)
+
+	for _, t := range targets {
+		switch t.Platform {
+		case storage.TargetRegex:
+			re, err := patterns.get(t.Pattern)
+			if err == nil && re.MatchString(ua) {
+				return t.URL
+			}
+		case platform:
+			return t.URL
+		}
+	}
+
+	return ""
+}
+
+// countryTarget returns the URL of the first of targets listing the country
+// of addr, or "" if none does or the country is unknown.
+func countryTarget(log *slog.Logger, targets []storage.CountryTarget, countries CountryLookup, addr netip.Addr) string {
+	if !addr.IsValid() {
+		return ""
+	}
+
+	country, err := countries.Country(addr)
+	if err != nil {
+		// A broken lookup must not break the link.
+		log.Warn("failed to look up country", slog.String("addr", addr.String()), sl.Err(err))
+		return ""
+	}
+	if country == "" {
+		return ""
+	}
+
+	for _, t := range targets {
+		if slices.Contains(t.Countries, country) {
+			return t.URL
+		}
+	}
+
+	return ""
+}
+
+// subpath returns the still escaped part of the request path below the
+// alias, without the leading slash.
+func subpath(r *http.Request) string {
+	_, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	return rest
+}
+
+// destination returns where a visit to link goes: the stored URL with utm
+// added and, for passthrough links, the escaped trailing path rest and the
+// visit's query. Parameters already present win over added ones, so the
+// stored URL beats utm, which beats the visit.
+func destination(link storage.URL, utm storage.UTM, rest string, query url.Values) (string, error) {
+	if utm.Empty() && !link.Passthrough {
+		return link.URL, nil
+	}
+
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		return "", err
+	}
+
+	addQuery(u, utm.Values())
 
-		http.Redirect(w, r, resURL, http.StatusFound)
+	if link.Passthrough {
+		if rest != "" {
+			u = u.JoinPath(rest)
+		}
+		addQuery(u, query)
 	}
+
+	return u.String(), nil
+}
+
+// addQuery appends the parameters of query that u does not have yet.
+func addQuery(u *url.URL, query url.Values) {
+	own := u.Query()
+	extra := url.Values{}
+	for key, values := range query {
+		if _, ok := own[key]; !ok {
+			extra[key] = values
+		}
+	}
+
+	if len(extra) == 0 {
+		return
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += extra.Encode()
+}
+
+// maxAge returns how many seconds a permanent redirect to link may be
+// cached: limit, but never past the link's expiry.
+func maxAge(link storage.URL, limit time.Duration) int64 {
+	if link.ExpiresAt != nil {
+		limit = min(limit, time.Until(*link.ExpiresAt))
+	}
+
+	return int64(max(limit, 0) / time.Second)
 }
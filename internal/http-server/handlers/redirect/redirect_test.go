@@ -3,24 +3,38 @@ package redirect
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"testing"
+	"time"
 
 	"urlShortener/internal/http-server/handlers/redirect/mocks"
 	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/clientip"
 	"urlShortener/internal/lib/slogdiscard"
 	"urlShortener/internal/storage"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
+var testConfig = Config{
+	DefaultStatus:   http.StatusFound,
+	PermanentMaxAge: time.Hour,
+	PendingStatus:   http.StatusNotFound,
+	PendingMessage:  "not found",
+}
+
 func TestRedirectHandler_EmptyAlias(t *testing.T) {
 	mockGetter := mocks.NewURLGetter(t)
+	mockRecorder := mocks.NewClickRecorder(t)
 
-	handler := New(slogdiscard.NewDiscardLogger(), mockGetter)
+	handler := New(slogdiscard.NewDiscardLogger(), mockGetter, mockRecorder, testConfig)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	rec := httptest.NewRecorder()
@@ -36,37 +50,242 @@ func TestRedirectHandler_EmptyAlias(t *testing.T) {
 }
 
 func TestRedirectHandler(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
 	cases := []struct {
 		name         string
 		alias        string
+		path         string
+		ua           string
 		mockSetup    func(m *mocks.URLGetter)
+		wantClick    bool
 		wantRedirect string
 		wantStatus   int
+		wantCache    string
 		wantError    string
 	}{
 		{
 			name:  "success redirect",
 			alias: "google",
 			mockSetup: func(m *mocks.URLGetter) {
-				m.On("GetURL", "google").Return("https://google.com", nil)
+				m.On("GetURL", mock.Anything, "google").Return(storage.URL{URL: "https://google.com"}, nil)
 			},
+			wantClick:    true,
 			wantRedirect: "https://google.com",
 			wantStatus:   http.StatusFound,
 		},
+		{
+			name:  "link status",
+			alias: "api",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "api").
+					Return(storage.URL{URL: "https://api.example.com", URLOptions: storage.URLOptions{RedirectStatus: http.StatusTemporaryRedirect}}, nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://api.example.com",
+			wantStatus:   http.StatusTemporaryRedirect,
+		},
+		{
+			name:  "permanent redirect",
+			alias: "seo",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "seo").
+					Return(storage.URL{URL: "https://example.com", URLOptions: storage.URLOptions{RedirectStatus: http.StatusMovedPermanently}}, nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com",
+			wantStatus:   http.StatusMovedPermanently,
+			wantCache:    "public, max-age=3600",
+		},
+		{
+			name:  "passthrough",
+			alias: "docs",
+			path:  "/docs/guide/intro?lang=en&v=9",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "docs").
+					Return(storage.URL{URL: "https://example.com/docs/?v=2", URLOptions: storage.URLOptions{Passthrough: true}}, nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com/docs/guide/intro?v=2&lang=en",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:  "subpath without passthrough",
+			alias: "google",
+			path:  "/google/extra",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "google").Return(storage.URL{URL: "https://google.com"}, nil)
+			},
+			wantStatus: http.StatusNotFound,
+			wantError:  "not found",
+		},
 		{
 			name:  "url not found",
 			alias: "unknown",
 			mockSetup: func(m *mocks.URLGetter) {
-				m.On("GetURL", "unknown").Return("", storage.ErrURLNotFound)
+				m.On("GetURL", mock.Anything, "unknown").Return(storage.URL{}, storage.ErrURLNotFound)
+			},
+			wantStatus: http.StatusNotFound,
+			wantError:  "not found",
+		},
+		{
+			name:  "utm template",
+			alias: "promo",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "promo").Return(storage.URL{
+					URL: "https://example.com/",
+					URLOptions: storage.URLOptions{
+						UTM:         storage.UTM{Campaign: "spring"},
+						UTMTemplate: "mail",
+					},
+				}, nil)
+				m.On("GetUTMTemplate", mock.Anything, "mail").
+					Return(storage.UTMTemplate{Name: "mail", UTM: storage.UTM{Source: "newsletter", Campaign: "default"}}, nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com/?utm_campaign=spring&utm_source=newsletter",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:  "missing utm template",
+			alias: "stale",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "stale").Return(storage.URL{
+					URL:        "https://example.com/",
+					URLOptions: storage.URLOptions{UTM: storage.UTM{Medium: "qr"}, UTMTemplate: "gone"},
+				}, nil)
+				m.On("GetUTMTemplate", mock.Anything, "gone").Return(storage.UTMTemplate{}, storage.ErrUTMTemplateNotFound)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com/?utm_medium=qr",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:  "url expired",
+			alias: "expired",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "expired").Return(storage.URL{}, storage.ErrURLExpired)
+			},
+			wantStatus: http.StatusGone,
+			wantError:  "url expired",
+		},
+		{
+			name:  "click limit",
+			alias: "invite",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "invite").
+					Return(storage.URL{URL: "https://example.com/join", ClicksLeft: 1, URLOptions: storage.URLOptions{MaxClicks: 1}}, nil)
+				m.On("ConsumeClick", mock.Anything, "invite").Return(nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com/join",
+			wantStatus:   http.StatusFound,
+			wantCache:    "no-store",
+		},
+		{
+			name:  "click limit with permanent redirect",
+			alias: "invite",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "invite").
+					Return(storage.URL{URL: "https://example.com/join", ClicksLeft: 1, URLOptions: storage.URLOptions{MaxClicks: 1, RedirectStatus: http.StatusPermanentRedirect}}, nil)
+				m.On("ConsumeClick", mock.Anything, "invite").Return(nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com/join",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantCache:    "no-store",
+		},
+		{
+			name:  "click limit taken concurrently",
+			alias: "invite",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "invite").
+					Return(storage.URL{URL: "https://example.com/join", ClicksLeft: 1, URLOptions: storage.URLOptions{MaxClicks: 1}}, nil)
+				m.On("ConsumeClick", mock.Anything, "invite").Return(storage.ErrURLExhausted)
+			},
+			wantStatus: http.StatusGone,
+			wantError:  "url click limit reached",
+		},
+		{
+			name:  "click limit reached",
+			alias: "used",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "used").Return(storage.URL{}, storage.ErrURLExhausted)
+			},
+			wantStatus: http.StatusGone,
+			wantError:  "url click limit reached",
+		},
+		{
+			name:  "not active yet",
+			alias: "launch",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "launch").
+					Return(storage.URL{URL: "https://example.com/launch", URLOptions: storage.URLOptions{NotBefore: &future}}, nil)
 			},
 			wantStatus: http.StatusNotFound,
+			wantCache:  "no-store",
 			wantError:  "not found",
 		},
+		{
+			name:  "not active yet with fallback",
+			alias: "launch",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "launch").Return(storage.URL{
+					URL:        "https://example.com/launch",
+					URLOptions: storage.URLOptions{NotBefore: &future, FallbackURL: "https://example.com/soon"},
+				}, nil)
+			},
+			wantRedirect: "https://example.com/soon",
+			wantStatus:   http.StatusFound,
+			wantCache:    "no-store",
+		},
+		{
+			name:  "active",
+			alias: "launch",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "launch").Return(storage.URL{
+					URL:        "https://example.com/launch",
+					URLOptions: storage.URLOptions{NotBefore: &past, FallbackURL: "https://example.com/soon"},
+				}, nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com/launch",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:  "target",
+			alias: "app",
+			ua:    "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) Mobile/15E148",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "app").Return(storage.URL{
+					URL:        "https://example.com/app",
+					URLOptions: storage.URLOptions{Targets: []storage.Target{{Platform: storage.TargetIOS, URL: "https://apps.apple.com/app/id1"}}},
+				}, nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://apps.apple.com/app/id1",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:  "no matching target",
+			alias: "app",
+			ua:    "curl/8.5.0",
+			mockSetup: func(m *mocks.URLGetter) {
+				m.On("GetURL", mock.Anything, "app").Return(storage.URL{
+					URL:        "https://example.com/app",
+					URLOptions: storage.URLOptions{Targets: []storage.Target{{Platform: storage.TargetIOS, URL: "https://apps.apple.com/app/id1"}}},
+				}, nil)
+			},
+			wantClick:    true,
+			wantRedirect: "https://example.com/app",
+			wantStatus:   http.StatusFound,
+		},
 		{
 			name:  "internal error",
 			alias: "test",
 			mockSetup: func(m *mocks.URLGetter) {
-				m.On("GetURL", "test").Return("", errors.New("db error"))
+				m.On("GetURL", mock.Anything, "test").Return(storage.URL{}, errors.New("db error"))
 			},
 			wantStatus: http.StatusInternalServerError,
 			wantError:  "internal error",
@@ -78,12 +297,24 @@ func TestRedirectHandler(t *testing.T) {
 			mockGetter := mocks.NewURLGetter(t)
 			tc.mockSetup(mockGetter)
 
-			handler := New(slogdiscard.NewDiscardLogger(), mockGetter)
+			mockRecorder := mocks.NewClickRecorder(t)
+			if tc.wantClick {
+				mockRecorder.On("Record", tc.alias, mock.Anything).Once()
+			}
+
+			handler := New(slogdiscard.NewDiscardLogger(), mockGetter, mockRecorder, testConfig)
 
 			r := chi.NewRouter()
 			r.Get("/{alias}", handler)
+			r.Get("/{alias}/*", handler)
+
+			path := tc.path
+			if path == "" {
+				path = "/" + tc.alias
+			}
 
-			req := httptest.NewRequest(http.MethodGet, "/"+tc.alias, nil)
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("User-Agent", tc.ua)
 			rec := httptest.NewRecorder()
 
 			r.ServeHTTP(rec, req)
@@ -94,6 +325,8 @@ func TestRedirectHandler(t *testing.T) {
 				assert.Equal(t, tc.wantRedirect, rec.Header().Get("Location"))
 			}
 
+			assert.Equal(t, tc.wantCache, rec.Header().Get("Cache-Control"))
+
 			if tc.wantError != "" {
 				var response resp.Response
 				err := json.Unmarshal(rec.Body.Bytes(), &response)
@@ -104,3 +337,281 @@ func TestRedirectHandler(t *testing.T) {
 	}
 }
 
+func TestRedirectHandler_Country(t *testing.T) {
+	link := storage.URL{
+		URL: "https://example.com/",
+		URLOptions: storage.URLOptions{
+			Targets: []storage.Target{{Platform: storage.TargetIOS, URL: "https://apps.apple.com/app/id1"}},
+			CountryTargets: []storage.CountryTarget{
+				{Countries: []string{"DE", "AT"}, URL: "https://example.de/"},
+				{Countries: []string{"GB"}, URL: "https://example.co.uk/"},
+			},
+		},
+	}
+
+	proxies, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name         string
+		remoteAddr   string
+		forwarded    string
+		ua           string
+		status       int
+		mockSetup    func(m *mocks.CountryLookup)
+		wantRedirect string
+		wantCache    string
+	}{
+		{
+			name:       "country",
+			remoteAddr: "198.51.100.7:4711",
+			mockSetup: func(m *mocks.CountryLookup) {
+				m.On("Country", netip.MustParseAddr("198.51.100.7")).Return("AT", nil)
+			},
+			wantRedirect: "https://example.de/",
+		},
+		{
+			name:       "no matching country",
+			remoteAddr: "198.51.100.7:4711",
+			mockSetup: func(m *mocks.CountryLookup) {
+				m.On("Country", mock.Anything).Return("FR", nil)
+			},
+			wantRedirect: "https://example.com/",
+		},
+		{
+			name:       "unknown country",
+			remoteAddr: "198.51.100.7:4711",
+			mockSetup: func(m *mocks.CountryLookup) {
+				m.On("Country", mock.Anything).Return("", nil)
+			},
+			wantRedirect: "https://example.com/",
+		},
+		{
+			name:       "lookup error",
+			remoteAddr: "198.51.100.7:4711",
+			mockSetup: func(m *mocks.CountryLookup) {
+				m.On("Country", mock.Anything).Return("", errors.New("broken database"))
+			},
+			wantRedirect: "https://example.com/",
+		},
+		{
+			name:         "platform target first",
+			remoteAddr:   "198.51.100.7:4711",
+			ua:           "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) Mobile/15E148",
+			mockSetup:    func(m *mocks.CountryLookup) {},
+			wantRedirect: "https://apps.apple.com/app/id1",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.2:4711",
+			forwarded:  "203.0.113.9, 10.0.0.1",
+			mockSetup: func(m *mocks.CountryLookup) {
+				m.On("Country", netip.MustParseAddr("203.0.113.9")).Return("GB", nil)
+			},
+			wantRedirect: "https://example.co.uk/",
+		},
+		{
+			name:       "forwarded by a client",
+			remoteAddr: "198.51.100.7:4711",
+			forwarded:  "203.0.113.9",
+			mockSetup: func(m *mocks.CountryLookup) {
+				m.On("Country", netip.MustParseAddr("198.51.100.7")).Return("FR", nil)
+			},
+			wantRedirect: "https://example.com/",
+		},
+		{
+			name:       "permanent",
+			remoteAddr: "198.51.100.7:4711",
+			status:     http.StatusMovedPermanently,
+			mockSetup: func(m *mocks.CountryLookup) {
+				m.On("Country", mock.Anything).Return("DE", nil)
+			},
+			wantRedirect: "https://example.de/",
+			wantCache:    "private, max-age=3600",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link := link
+			link.RedirectStatus = tc.status
+
+			mockGetter := mocks.NewURLGetter(t)
+			mockGetter.On("GetURL", mock.Anything, "geo").Return(link, nil)
+
+			mockRecorder := mocks.NewClickRecorder(t)
+			mockRecorder.On("Record", "geo", mock.Anything).Once()
+
+			mockCountries := mocks.NewCountryLookup(t)
+			tc.mockSetup(mockCountries)
+
+			cfg := testConfig
+			cfg.Countries = mockCountries
+			cfg.Proxies = proxies
+
+			r := chi.NewRouter()
+			r.Get("/{alias}", New(slogdiscard.NewDiscardLogger(), mockGetter, mockRecorder, cfg))
+
+			req := httptest.NewRequest(http.MethodGet, "/geo", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("User-Agent", tc.ua)
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			wantStatus := tc.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusFound
+			}
+			require.Equal(t, wantStatus, rec.Code)
+			assert.Equal(t, tc.wantRedirect, rec.Header().Get("Location"))
+			assert.Equal(t, tc.wantCache, rec.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestRedirectHandler_CountryDisabled(t *testing.T) {
+	mockGetter := mocks.NewURLGetter(t)
+	mockGetter.On("GetURL", mock.Anything, "geo").Return(storage.URL{
+		URL:        "https://example.com/",
+		URLOptions: storage.URLOptions{CountryTargets: []storage.CountryTarget{{Countries: []string{"DE"}, URL: "https://example.de/"}}},
+	}, nil)
+
+	mockRecorder := mocks.NewClickRecorder(t)
+	mockRecorder.On("Record", "geo", mock.Anything).Once()
+
+	r := chi.NewRouter()
+	r.Get("/{alias}", New(slogdiscard.NewDiscardLogger(), mockGetter, mockRecorder, testConfig))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/geo", nil))
+
+	require.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://example.com/", rec.Header().Get("Location"))
+}
+
+func TestMaxAge(t *testing.T) {
+	assert.EqualValues(t, 3600, maxAge(storage.URL{}, time.Hour))
+
+	soon := time.Now().Add(10 * time.Minute)
+	got := maxAge(storage.URL{URLOptions: storage.URLOptions{ExpiresAt: &soon}}, time.Hour)
+	assert.InDelta(t, 600, got, 1)
+
+	past := time.Now().Add(-time.Minute)
+	assert.Zero(t, maxAge(storage.URL{URLOptions: storage.URLOptions{ExpiresAt: &past}}, time.Hour))
+}
+
+func TestDestination(t *testing.T) {
+	passthrough := storage.URLOptions{Passthrough: true}
+
+	cases := []struct {
+		name  string
+		link  storage.URL
+		utm   storage.UTM
+		rest  string
+		query url.Values
+		want  string
+	}{
+		{
+			name:  "plain link",
+			link:  storage.URL{URL: "https://example.com/a?b=1#top"},
+			query: url.Values{"q": {"go"}},
+			want:  "https://example.com/a?b=1#top",
+		},
+		{
+			name: "path below root",
+			link: storage.URL{URL: "https://example.com", URLOptions: passthrough},
+			rest: "x/y",
+			want: "https://example.com/x/y",
+		},
+		{
+			name: "escaped path",
+			link: storage.URL{URL: "https://example.com/files/", URLOptions: passthrough},
+			rest: "a%20b/c%2Fd",
+			want: "https://example.com/files/a%20b/c%2Fd",
+		},
+		{
+			name:  "destination wins on conflicts",
+			link:  storage.URL{URL: "https://example.com/?ref=owner", URLOptions: passthrough},
+			query: url.Values{"ref": {"visitor"}, "q": {"go"}},
+			want:  "https://example.com/?ref=owner&q=go",
+		},
+		{
+			name:  "query and fragment",
+			link:  storage.URL{URL: "https://example.com/page#part", URLOptions: passthrough},
+			query: url.Values{"a": {"1", "2"}},
+			want:  "https://example.com/page?a=1&a=2#part",
+		},
+		{
+			name: "utm",
+			link: storage.URL{URL: "https://example.com/?utm_medium=own"},
+			utm:  storage.UTM{Source: "news letter", Medium: "email"},
+			want: "https://example.com/?utm_medium=own&utm_source=news+letter",
+		},
+		{
+			name:  "utm wins over the visit",
+			link:  storage.URL{URL: "https://example.com/", URLOptions: passthrough},
+			utm:   storage.UTM{Source: "mail"},
+			query: url.Values{"utm_source": {"visitor"}, "utm_term": {"go"}},
+			want:  "https://example.com/?utm_source=mail&utm_term=go",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := destination(tc.link, tc.utm, tc.rest, tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestTarget(t *testing.T) {
+	targets := []storage.Target{
+		{Platform: storage.TargetRegex, Pattern: `Kindle/\d`, URL: "https://example.com/kindle"},
+		{Platform: storage.TargetIOS, URL: "https://apps.apple.com/app/id1"},
+		{Platform: storage.TargetAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+		{Platform: storage.TargetDesktop, URL: "https://example.com/desktop"},
+	}
+
+	cases := []struct {
+		name string
+		ua   string
+		want string
+	}{
+		{"ios", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) Mobile/15E148", "https://apps.apple.com/app/id1"},
+		{"android", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", "https://play.google.com/store/apps/details?id=app"},
+		{"desktop", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/124.0.0.0", "https://example.com/desktop"},
+		{"regex before platform", "Mozilla/5.0 (Linux; Android 4.0; Kindle/3.0) Mobile", "https://example.com/kindle"},
+		{"no match", "curl/8.5.0", ""},
+	}
+
+	patterns := newPatterns()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, target(patterns, targets, tc.ua))
+		})
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	p := newPatterns()
+
+	re, err := p.get(`Kindle/\d`)
+	require.NoError(t, err)
+	again, err := p.get(`Kindle/\d`)
+	require.NoError(t, err)
+	assert.Same(t, re, again, "pattern compiled twice")
+
+	_, err = p.get(`(`)
+	assert.Error(t, err)
+
+	for i := range maxPatterns + 1 {
+		_, err := p.get(fmt.Sprintf("p%d", i))
+		require.NoError(t, err)
+	}
+	assert.LessOrEqual(t, len(p.compiled), maxPatterns)
+}
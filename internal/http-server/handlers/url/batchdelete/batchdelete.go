@@ -0,0 +1,106 @@
+package batchdelete
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+// Request names the links to delete: either Aliases, or the filters
+// CreatedBefore and Domain, which are combined with AND.
+type Request struct {
+	Aliases       []string   `json:"aliases,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	Domain        string     `json:"domain,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Deleted  int64    `json:"deleted"`
+	NotFound []string `json:"not_found,omitempty"`
+}
+
+type URLsDeleter interface {
+	DeleteURLs(ctx context.Context, q storage.DeleteQuery) (storage.DeleteResult, error)
+}
+
+// New deletes up to maxItems listed aliases, or every link matching the
+// filters, in one storage transaction. Listed aliases that do not exist are
+// reported in not_found.
+func New(log *slog.Logger, urlsDeleter URLsDeleter, maxItems int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.batchdelete.New"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validate(req, maxItems); err != nil {
+			log.Error("invalid request", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+			return
+		}
+
+		res, err := urlsDeleter.DeleteURLs(r.Context(), storage.DeleteQuery{
+			Aliases:       req.Aliases,
+			CreatedBefore: req.CreatedBefore,
+			Domain:        req.Domain,
+		})
+		if err != nil {
+			log.Error("failed to delete urls", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		log.Info("urls deleted", slog.Int64("deleted", res.Deleted), slog.Int("not_found", len(res.NotFound)))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Deleted:  res.Deleted,
+			NotFound: res.NotFound,
+		})
+	}
+}
+
+func validate(req Request, maxItems int) error {
+	hasFilter := req.CreatedBefore != nil || req.Domain != ""
+
+	switch {
+	case len(req.Aliases) > 0 && hasFilter:
+		return errors.New("field aliases cannot be used together with created_before or domain")
+	case len(req.Aliases) > maxItems:
+		return fmt.Errorf("field aliases must contain at most %d items", maxItems)
+	case len(req.Aliases) == 0 && !hasFilter:
+		return errors.New("one of aliases, created_before or domain is required")
+	}
+
+	for _, alias := range req.Aliases {
+		if alias == "" {
+			return errors.New("field aliases must not contain empty aliases")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package batchdelete
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"urlShortener/internal/http-server/handlers/url/batchdelete/mocks"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const maxItems = 3
+
+func TestBatchDeleteHandler(t *testing.T) {
+	before := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name         string
+		body         string
+		mockSetup    func(m *mocks.URLsDeleter)
+		wantCode     int
+		wantError    string
+		wantDeleted  int64
+		wantNotFound []string
+	}{
+		{
+			name: "by aliases",
+			body: `{"aliases": ["a", "b", "missing"]}`,
+			mockSetup: func(m *mocks.URLsDeleter) {
+				m.On("DeleteURLs", mock.Anything, storage.DeleteQuery{Aliases: []string{"a", "b", "missing"}}).
+					Return(storage.DeleteResult{Deleted: 2, NotFound: []string{"missing"}}, nil)
+			},
+			wantCode:     http.StatusOK,
+			wantDeleted:  2,
+			wantNotFound: []string{"missing"},
+		},
+		{
+			name: "by filter",
+			body: `{"created_before": "2025-01-01T00:00:00Z", "domain": "google.com"}`,
+			mockSetup: func(m *mocks.URLsDeleter) {
+				m.On("DeleteURLs", mock.Anything, mock.MatchedBy(func(q storage.DeleteQuery) bool {
+					return q.Domain == "google.com" && q.CreatedBefore != nil && q.CreatedBefore.Equal(before)
+				})).Return(storage.DeleteResult{Deleted: 5}, nil)
+			},
+			wantCode:    http.StatusOK,
+			wantDeleted: 5,
+		},
+		{
+			name:      "aliases with filter",
+			body:      `{"aliases": ["a"], "domain": "google.com"}`,
+			mockSetup: func(m *mocks.URLsDeleter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field aliases cannot be used together with created_before or domain",
+		},
+		{
+			name:      "nothing selected",
+			body:      `{}`,
+			mockSetup: func(m *mocks.URLsDeleter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "one of aliases, created_before or domain is required",
+		},
+		{
+			name:      "too many aliases",
+			body:      `{"aliases": ["a", "b", "c", "d"]}`,
+			mockSetup: func(m *mocks.URLsDeleter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field aliases must contain at most 3 items",
+		},
+		{
+			name:      "empty alias",
+			body:      `{"aliases": ["a", ""]}`,
+			mockSetup: func(m *mocks.URLsDeleter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field aliases must not contain empty aliases",
+		},
+		{
+			name:      "invalid json",
+			body:      `{"aliases": `,
+			mockSetup: func(m *mocks.URLsDeleter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "failed to decode request",
+		},
+		{
+			name: "storage error",
+			body: `{"aliases": ["a"]}`,
+			mockSetup: func(m *mocks.URLsDeleter) {
+				m.On("DeleteURLs", mock.Anything, mock.Anything).Return(storage.DeleteResult{}, errors.New("db error"))
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantError: "internal error",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDeleter := mocks.NewURLsDeleter(t)
+			tc.mockSetup(mockDeleter)
+
+			handler := New(slogdiscard.NewDiscardLogger(), mockDeleter, maxItems)
+
+			req := httptest.NewRequest(http.MethodPost, "/url/batch/delete", bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			handler(rec, req)
+
+			require.Equal(t, tc.wantCode, rec.Code)
+
+			var response Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+			if tc.wantError != "" {
+				assert.Equal(t, tc.wantError, response.Error)
+				return
+			}
+
+			assert.Equal(t, "OK", response.Status)
+			assert.Equal(t, tc.wantDeleted, response.Deleted)
+			assert.Equal(t, tc.wantNotFound, response.NotFound)
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package batchsave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"urlShortener/internal/http-server/handlers/url/save"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/lib/metrics"
+	"urlShortener/internal/lib/random"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Request struct {
+	Items []save.Request `json:"items"`
+	// Atomic saves either every item or none of them.
+	Atomic bool `json:"atomic,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Items []Item `json:"items"`
+}
+
+// Item is the outcome of one request item, at the same index.
+type Item struct {
+	resp.Response
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type URLsSaver interface {
+	SaveURLs(ctx context.Context, urls []storage.NewURL, atomic bool) ([]storage.SaveResult, error)
+}
+
+// New creates up to maxItems links in one storage transaction. Items are
+// checked with validate like save.New does, and those without an alias get
+// one from aliases that no other item asks for, with a fresh one for each
+// that turns out to be taken. By default each item succeeds or fails on its
+// own; with atomic set, a single invalid item or taken alias rejects the
+// whole batch.
+func New(log *slog.Logger, urlsSaver URLsSaver, aliases random.AliasSource, validate *validator.Validate, maxItems int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.batchsave.New"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+			return
+		}
+
+		log.Info("request body decoded", slog.Int("items", len(req.Items)), slog.Bool("atomic", req.Atomic))
+
+		if len(req.Items) == 0 {
+			log.Error("batch is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("field items is a required field"))
+			return
+		}
+
+		if len(req.Items) > maxItems {
+			log.Error("batch is too large", slog.Int("items", len(req.Items)))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(fmt.Sprintf("field items must contain at most %d items", maxItems)))
+			return
+		}
+
+		items := make([]Item, len(req.Items))
+		urls := make([]storage.NewURL, 0, len(req.Items))
+		// positions maps an index in urls back to its request item.
+		positions := make([]int, 0, len(req.Items))
+		generated := make([]bool, 0, len(req.Items))
+
+		creator, _, _ := r.BasicAuth()
+
+		for i, item := range req.Items {
+			if err := validate.Struct(item); err != nil {
+				items[i].Response = resp.ValidationError(err.(validator.ValidationErrors))
+				continue
+			}
+
+			opts, err := item.Options(creator)
+			if msg := save.OptionsError(err); msg != "" {
+				items[i].Response = resp.Error(msg)
+				continue
+			}
+			if err != nil {
+				log.Error("failed to build url options", sl.Err(err))
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, resp.Error("failed to add urls"))
+				return
+			}
+
+			urls = append(urls, storage.NewURL{URL: item.URL, Alias: item.Alias, URLOptions: opts})
+			positions = append(positions, i)
+			generated = append(generated, item.Alias == "")
+		}
+
+		if req.Atomic && len(urls) < len(req.Items) {
+			log.Error("invalid items in atomic batch", slog.Int("invalid", len(req.Items)-len(urls)))
+			reject(w, r, items)
+			return
+		}
+
+		// Aliases are generated only once every alias the batch asks for is
+		// known, so that none of them is handed to another item.
+		aliases = reserve(aliases, urls)
+		for j := range urls {
+			if !generated[j] {
+				continue
+			}
+			urls[j].Alias, err = aliases.Alias(r.Context(), 0)
+			if err != nil {
+				log.Error("failed to generate alias", sl.Err(err))
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, resp.Error("failed to add urls"))
+				return
+			}
+		}
+
+		results, err := saveBatch(r.Context(), log, urlsSaver, urls, generated, req.Atomic, aliases)
+
+		var batchErr *storage.BatchError
+		if errors.As(err, &batchErr) && errors.Is(batchErr.Err, storage.ErrURLExists) {
+			log.Error("alias already exists", slog.String("alias", urls[batchErr.Index].Alias))
+			items[positions[batchErr.Index]].Response = aliasTaken(generated[batchErr.Index])
+			reject(w, r, items)
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to add urls", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to add urls"))
+			return
+		}
+
+		saved := 0
+		for j, res := range results {
+			i := positions[j]
+			if errors.Is(res.Err, storage.ErrURLExists) {
+				items[i].Response = aliasTaken(generated[j])
+				continue
+			}
+
+			items[i] = Item{
+				Response:  resp.OK(),
+				Alias:     urls[j].Alias,
+				ExpiresAt: urls[j].ExpiresAt,
+			}
+			saved++
+		}
+
+		log.Info("urls added", slog.Int("saved", saved), slog.Int("failed", len(items)-saved))
+
+		if saved < len(items) {
+			render.Status(r, http.StatusMultiStatus)
+			render.JSON(w, r, Response{
+				Response: resp.Error("some items were not saved"),
+				Items:    items,
+			})
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Items:    items,
+		})
+	}
+}
+
+// saveBatch saves urls, drawing new aliases from aliases for generated ones
+// that turn out to be taken. In atomic mode the whole batch is retried.
+func saveBatch(
+	ctx context.Context,
+	log *slog.Logger,
+	urlsSaver URLsSaver,
+	urls []storage.NewURL,
+	generated []bool,
+	atomic bool,
+	aliases random.AliasSource,
+) ([]storage.SaveResult, error) {
+	results := make([]storage.SaveResult, len(urls))
+
+	// pending lists the indexes in urls that still have to be saved.
+	pending := make([]int, len(urls))
+	for j := range pending {
+		pending[j] = j
+	}
+
+	for attempt := 1; ; attempt++ {
+		batch := make([]storage.NewURL, len(pending))
+		for k, j := range pending {
+			batch[k] = urls[j]
+		}
+
+		res, err := urlsSaver.SaveURLs(ctx, batch, atomic)
+
+		var batchErr *storage.BatchError
+		if errors.As(err, &batchErr) && errors.Is(batchErr.Err, storage.ErrURLExists) {
+			j := pending[batchErr.Index]
+			if !generated[j] {
+				return nil, &storage.BatchError{Index: j, Err: batchErr.Err}
+			}
+			ok, err := retry(ctx, log, &urls[j], attempt, aliases)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, &storage.BatchError{Index: j, Err: batchErr.Err}
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var again []int
+		for k, j := range pending {
+			results[j] = res[k]
+			if !errors.Is(res[k].Err, storage.ErrURLExists) || !generated[j] {
+				continue
+			}
+			ok, err := retry(ctx, log, &urls[j], attempt, aliases)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				again = append(again, j)
+			}
+		}
+
+		if len(again) == 0 {
+			return results, nil
+		}
+		pending = again
+	}
+}
+
+// reserve returns aliases that does not generate any of the aliases urls
+// ask for. They are compared case-insensitively, which is how the storage
+// may match them.
+func reserve(aliases random.AliasSource, urls []storage.NewURL) random.AliasSource {
+	reserved := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		if u.Alias != "" {
+			reserved[storage.CanonicalAlias(u.Alias)] = true
+		}
+	}
+	if len(reserved) == 0 {
+		return aliases
+	}
+
+	allowed := aliases.Allowed
+	aliases.Allowed = func(alias string) bool {
+		return !reserved[storage.CanonicalAlias(alias)] && (allowed == nil || allowed(alias))
+	}
+
+	return aliases
+}
+
+// retry gives u a fresh alias after its generated one was taken on the given
+// attempt. It reports false once aliases allows no more attempts.
+func retry(ctx context.Context, log *slog.Logger, u *storage.NewURL, attempt int, aliases random.AliasSource) (bool, error) {
+	metrics.AliasCollisions.Add(1)
+	log.Warn("generated alias already exists", slog.String("alias", u.Alias), slog.Int("attempt", attempt))
+
+	if attempt >= aliases.MaxAttempts {
+		metrics.AliasExhausted.Add(1)
+		return false, nil
+	}
+
+	alias, err := aliases.Alias(ctx, attempt)
+	if err != nil {
+		return false, err
+	}
+	u.Alias = alias
+
+	return true, nil
+}
+
+// aliasTaken is the item error for an alias that is already in use. Only
+// aliases the client chose are reported as conflicts.
+func aliasTaken(generated bool) resp.Response {
+	if generated {
+		return resp.Error("failed to generate a free alias")
+	}
+
+	return resp.Error("alias already exists")
+}
+
+// reject answers an atomic batch that was not saved. Items without an error
+// of their own are marked as not saved.
+func reject(w http.ResponseWriter, r *http.Request, items []Item) {
+	for i := range items {
+		if items[i].Status == "" {
+			items[i].Response = resp.Error("not saved")
+		}
+	}
+
+	render.Status(r, http.StatusUnprocessableEntity)
+	render.JSON(w, r, Response{
+		Response: resp.Error("batch was not saved"),
+		Items:    items,
+	})
+}
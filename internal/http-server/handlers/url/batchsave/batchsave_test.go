@@ -0,0 +1,275 @@
+package batchsave
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"urlShortener/internal/http-server/handlers/url/batchsave/mocks"
+	"urlShortener/internal/lib/aliasrule"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/random"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const maxItems = 3
+
+func TestBatchSaveHandler(t *testing.T) {
+	cases := []struct {
+		name      string
+		body      string
+		mockSetup func(m *mocks.URLsSaver)
+		wantCode  int
+		wantError string
+		wantItems []Item
+	}{
+		{
+			name: "all saved",
+			body: `{"items": [{"url": "https://google.com", "alias": "google"}, {"url": "https://example.com", "alias": "example"}]}`,
+			mockSetup: func(m *mocks.URLsSaver) {
+				m.On("SaveURLs", mock.Anything, []storage.NewURL{
+					{URL: "https://google.com", Alias: "google"},
+					{URL: "https://example.com", Alias: "example"},
+				}, false).Return([]storage.SaveResult{{ID: 1}, {ID: 2}}, nil)
+			},
+			wantCode: http.StatusOK,
+			wantItems: []Item{
+				{Response: resp.OK(), Alias: "google"},
+				{Response: resp.OK(), Alias: "example"},
+			},
+		},
+		{
+			name: "best effort with failures",
+			body: `{"items": [{"url": "https://google.com", "alias": "google"}, {"url": "invalid"}, {"url": "https://example.com", "alias": "taken"}]}`,
+			mockSetup: func(m *mocks.URLsSaver) {
+				m.On("SaveURLs", mock.Anything, []storage.NewURL{
+					{URL: "https://google.com", Alias: "google"},
+					{URL: "https://example.com", Alias: "taken"},
+				}, false).Return([]storage.SaveResult{{ID: 1}, {Err: storage.ErrURLExists}}, nil)
+			},
+			wantCode:  http.StatusMultiStatus,
+			wantError: "some items were not saved",
+			wantItems: []Item{
+				{Response: resp.OK(), Alias: "google"},
+				{Response: resp.Error("field URL is not a valid URL")},
+				{Response: resp.Error("alias already exists")},
+			},
+		},
+		{
+			name:      "atomic with invalid item",
+			body:      `{"atomic": true, "items": [{"url": "https://google.com", "alias": "google"}, {"url": ""}]}`,
+			mockSetup: func(m *mocks.URLsSaver) {},
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "batch was not saved",
+			wantItems: []Item{
+				{Response: resp.Error("not saved")},
+				{Response: resp.Error("field URL is a required field")},
+			},
+		},
+		{
+			name: "atomic with taken alias",
+			body: `{"atomic": true, "items": [{"url": "https://google.com", "alias": "google"}, {"url": "https://example.com", "alias": "taken"}]}`,
+			mockSetup: func(m *mocks.URLsSaver) {
+				m.On("SaveURLs", mock.Anything, mock.Anything, true).
+					Return(nil, &storage.BatchError{Index: 1, Err: storage.ErrURLExists})
+			},
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "batch was not saved",
+			wantItems: []Item{
+				{Response: resp.Error("not saved")},
+				{Response: resp.Error("alias already exists")},
+			},
+		},
+		{
+			name:      "empty batch",
+			body:      `{"items": []}`,
+			mockSetup: func(m *mocks.URLsSaver) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field items is a required field",
+		},
+		{
+			name:      "too many items",
+			body:      `{"items": [{"url": "https://a.com"}, {"url": "https://b.com"}, {"url": "https://c.com"}, {"url": "https://d.com"}]}`,
+			mockSetup: func(m *mocks.URLsSaver) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field items must contain at most 3 items",
+		},
+		{
+			name:      "invalid json",
+			body:      `{"items": `,
+			mockSetup: func(m *mocks.URLsSaver) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "failed to decode request",
+		},
+		{
+			name: "storage error",
+			body: `{"items": [{"url": "https://google.com", "alias": "google"}]}`,
+			mockSetup: func(m *mocks.URLsSaver) {
+				m.On("SaveURLs", mock.Anything, mock.Anything, false).Return(nil, errors.New("db error"))
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantError: "failed to add urls",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockSaver := mocks.NewURLsSaver(t)
+			tc.mockSetup(mockSaver)
+
+			handler := New(slogdiscard.NewDiscardLogger(), mockSaver, testAliases(), testValidator(t), maxItems)
+
+			req := httptest.NewRequest(http.MethodPost, "/url/batch", bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			handler(rec, req)
+
+			require.Equal(t, tc.wantCode, rec.Code)
+
+			var response Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+			if tc.wantError != "" {
+				assert.Equal(t, tc.wantError, response.Error)
+			} else {
+				assert.Equal(t, "OK", response.Status)
+			}
+			assert.Equal(t, tc.wantItems, response.Items)
+		})
+	}
+}
+
+func TestBatchSaveHandler_GeneratedAlias(t *testing.T) {
+	mockSaver := mocks.NewURLsSaver(t)
+	mockSaver.On("SaveURLs", mock.Anything, mock.MatchedBy(func(urls []storage.NewURL) bool {
+		return len(urls) == 1 && urls[0].Alias == "1" && urls[0].CreatedBy == "alice"
+	}), false).Return([]storage.SaveResult{{ID: 1}}, nil)
+
+	handler := New(slogdiscard.NewDiscardLogger(), mockSaver, testAliases(), testValidator(t), maxItems)
+
+	req := httptest.NewRequest(http.MethodPost, "/url/batch", bytes.NewBufferString(`{"items": [{"url": "https://google.com"}]}`))
+	req.SetBasicAuth("alice", "secret")
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.Len(t, response.Items, 1)
+	assert.Equal(t, "1", response.Items[0].Alias)
+}
+
+func TestBatchSaveHandler_GeneratedAliasRetry(t *testing.T) {
+	mockSaver := mocks.NewURLsSaver(t)
+	mockSaver.On("SaveURLs", mock.Anything, []storage.NewURL{
+		{URL: "https://google.com", Alias: "1"},
+		{URL: "https://example.com", Alias: "taken"},
+	}, false).Return([]storage.SaveResult{{Err: storage.ErrURLExists}, {Err: storage.ErrURLExists}}, nil).Once()
+	mockSaver.On("SaveURLs", mock.Anything, []storage.NewURL{
+		{URL: "https://google.com", Alias: "2"},
+	}, false).Return([]storage.SaveResult{{ID: 1}}, nil).Once()
+
+	handler := New(slogdiscard.NewDiscardLogger(), mockSaver, testAliases(), testValidator(t), maxItems)
+
+	body := `{"items": [{"url": "https://google.com"}, {"url": "https://example.com", "alias": "taken"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/url/batch", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	require.Equal(t, http.StatusMultiStatus, rec.Code)
+
+	var response Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []Item{
+		{Response: resp.OK(), Alias: "2"},
+		{Response: resp.Error("alias already exists")},
+	}, response.Items)
+}
+
+func TestBatchSaveHandler_GeneratedAliasAvoidsRequested(t *testing.T) {
+	// The generator's first alias is "1", which a later item asks for.
+	mockSaver := mocks.NewURLsSaver(t)
+	mockSaver.On("SaveURLs", mock.Anything, []storage.NewURL{
+		{URL: "https://google.com", Alias: "2"},
+		{URL: "https://example.com", Alias: "1"},
+	}, true).Return([]storage.SaveResult{{ID: 1}, {ID: 2}}, nil).Once()
+
+	handler := New(slogdiscard.NewDiscardLogger(), mockSaver, testAliases(), testValidator(t), maxItems)
+
+	body := `{"atomic": true, "items": [{"url": "https://google.com"}, {"url": "https://example.com", "alias": "1"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/url/batch", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []Item{
+		{Response: resp.OK(), Alias: "2"},
+		{Response: resp.OK(), Alias: "1"},
+	}, response.Items)
+}
+
+func TestBatchSaveHandler_AtomicGeneratedAliasExhausted(t *testing.T) {
+	mockSaver := mocks.NewURLsSaver(t)
+	mockSaver.On("SaveURLs", mock.Anything, mock.Anything, true).
+		Return(nil, &storage.BatchError{Index: 0, Err: storage.ErrURLExists}).Times(2)
+
+	aliases := testAliases()
+	aliases.MaxAttempts = 2
+	handler := New(slogdiscard.NewDiscardLogger(), mockSaver, aliases, testValidator(t), maxItems)
+
+	body := `{"atomic": true, "items": [{"url": "https://google.com"}, {"url": "https://example.com", "alias": "example"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/url/batch", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	var response Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []Item{
+		{Response: resp.Error("failed to generate a free alias")},
+		{Response: resp.Error("not saved")},
+	}, response.Items)
+}
+
+func testValidator(t *testing.T) *validator.Validate {
+	t.Helper()
+
+	validate, err := aliasrule.NewValidator(aliasrule.Rules{Charset: "a-z0-9"})
+	require.NoError(t, err)
+
+	return validate
+}
+
+func testAliases() random.AliasSource {
+	return random.AliasSource{Generator: random.NewSequentialGenerator(counter()), MaxAttempts: 3}
+}
+
+// counter counts up from 1, as the database sequence does.
+func counter() random.Counter {
+	var next atomic.Int64
+
+	return func(context.Context) (int64, error) {
+		return next.Add(1), nil
+	}
+}
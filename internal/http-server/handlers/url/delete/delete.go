@@ -1,6 +1,7 @@
 package delete
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -14,14 +15,14 @@ import (
 )
 
 type URLDeleter interface {
-	DeleteURL(alias string) error
+	DeleteURL(ctx context.Context, alias string) error
 }
 
 func New(log *slog.Logger, urlDeleter URLDeleter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.delete.New"
 
-		log = log.With(slog.String("op", op),
+		log := log.With(slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())))
 
 		alias := chi.URLParam(r, "alias")
@@ -32,7 +33,7 @@ func New(log *slog.Logger, urlDeleter URLDeleter) http.HandlerFunc {
 			return
 		}
 
-		err := urlDeleter.DeleteURL(alias)
+		err := urlDeleter.DeleteURL(r.Context(), alias)
 		if errors.Is(err, storage.ErrURLNotFound) {
 			log.Info("url not found", "alias", alias)
 			render.Status(r, http.StatusNotFound)
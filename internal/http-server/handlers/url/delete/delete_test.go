@@ -14,6 +14,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -47,7 +48,7 @@ func TestDeleteHandler(t *testing.T) {
 			name:  "success delete",
 			alias: "google",
 			mockSetup: func(m *mocks.URLDeleter) {
-				m.On("DeleteURL", "google").Return(nil)
+				m.On("DeleteURL", mock.Anything, "google").Return(nil)
 			},
 			wantStatus: http.StatusOK,
 		},
@@ -55,7 +56,7 @@ func TestDeleteHandler(t *testing.T) {
 			name:  "url not found",
 			alias: "unknown",
 			mockSetup: func(m *mocks.URLDeleter) {
-				m.On("DeleteURL", "unknown").Return(storage.ErrURLNotFound)
+				m.On("DeleteURL", mock.Anything, "unknown").Return(storage.ErrURLNotFound)
 			},
 			wantStatus: http.StatusNotFound,
 			wantError:  "not found",
@@ -64,7 +65,7 @@ func TestDeleteHandler(t *testing.T) {
 			name:  "internal error",
 			alias: "test",
 			mockSetup: func(m *mocks.URLDeleter) {
-				m.On("DeleteURL", "test").Return(errors.New("db error"))
+				m.On("DeleteURL", mock.Anything, "test").Return(errors.New("db error"))
 			},
 			wantStatus: http.StatusInternalServerError,
 			wantError:  "internal error",
@@ -2,24 +2,28 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // URLDeleter is an autogenerated mock type for the URLDeleter type
 type URLDeleter struct {
 	mock.Mock
 }
 
-// DeleteURL provides a mock function with given fields: alias
-func (_m *URLDeleter) DeleteURL(alias string) error {
-	ret := _m.Called(alias)
+// DeleteURL provides a mock function with given fields: ctx, alias
+func (_m *URLDeleter) DeleteURL(ctx context.Context, alias string) error {
+	ret := _m.Called(ctx, alias)
 
 	if len(ret) == 0 {
 		panic("no return value specified for DeleteURL")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(alias)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, alias)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -40,4 +44,3 @@ func NewURLDeleter(t interface {
 
 	return mock
 }
-
@@ -0,0 +1,117 @@
+package get
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"urlShortener/internal/http-server/handlers/url/save"
+	"urlShortener/internal/http-server/handlers/url/update"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	resp.Response
+	ID        int64      `json:"id"`
+	Alias     string     `json:"alias"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	// RedirectStatus is omitted for links using the server default.
+	RedirectStatus int       `json:"redirect_status,omitempty"`
+	Passthrough    bool      `json:"passthrough,omitempty"`
+	UTM            *save.UTM `json:"utm,omitempty"`
+	UTMTemplate    string    `json:"utm_template,omitempty"`
+	// PasswordProtected is set for links behind a password. The hash
+	// itself is never returned.
+	PasswordProtected bool  `json:"password_protected,omitempty"`
+	MaxClicks         int64 `json:"max_clicks,omitempty"`
+	// ClicksLeft is only set for links with MaxClicks.
+	ClicksLeft     *int64               `json:"clicks_left,omitempty"`
+	NotBefore      *time.Time           `json:"not_before,omitempty"`
+	FallbackURL    string               `json:"fallback_url,omitempty"`
+	Targets        []save.Target        `json:"targets,omitempty"`
+	CountryTargets []save.CountryTarget `json:"country_targets,omitempty"`
+}
+
+type URLInfoGetter interface {
+	GetURLInfo(ctx context.Context, alias string) (storage.URL, error)
+}
+
+// New returns the stored record for an alias without redirecting. The
+// response carries the link's ETag for use with If-Match on update.
+func New(log *slog.Logger, urlInfoGetter URLInfoGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.get.New"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		alias := chi.URLParam(r, "alias")
+		if alias == "" {
+			log.Error("alias is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid request"))
+			return
+		}
+
+		u, err := urlInfoGetter.GetURLInfo(r.Context(), alias)
+		if errors.Is(err, storage.ErrURLNotFound) {
+			log.Info("url not found", "alias", alias)
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("not found"))
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to get url", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		w.Header().Set("ETag", update.ETag(u.Version))
+
+		var utm *save.UTM
+		if !u.UTM.Empty() {
+			v := save.NewUTM(u.UTM)
+			utm = &v
+		}
+
+		var clicksLeft *int64
+		if u.MaxClicks > 0 {
+			clicksLeft = &u.ClicksLeft
+		}
+
+		render.JSON(w, r, Response{
+			Response:          resp.OK(),
+			ID:                u.ID,
+			Alias:             u.Alias,
+			URL:               u.URL,
+			CreatedAt:         u.CreatedAt,
+			UpdatedAt:         u.UpdatedAt,
+			ExpiresAt:         u.ExpiresAt,
+			CreatedBy:         u.CreatedBy,
+			RedirectStatus:    u.RedirectStatus,
+			Passthrough:       u.Passthrough,
+			UTM:               utm,
+			UTMTemplate:       u.UTMTemplate,
+			PasswordProtected: u.PasswordHash != "",
+			MaxClicks:         u.MaxClicks,
+			ClicksLeft:        clicksLeft,
+			NotBefore:         u.NotBefore,
+			FallbackURL:       u.FallbackURL,
+			Targets:           save.NewTargets(u.Targets),
+			CountryTargets:    save.NewCountryTargets(u.CountryTargets),
+		})
+	}
+}
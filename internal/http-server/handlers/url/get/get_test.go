@@ -0,0 +1,113 @@
+package get
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"urlShortener/internal/http-server/handlers/url/get/mocks"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHandler(t *testing.T) {
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	updatedAt := createdAt.Add(time.Hour)
+
+	cases := []struct {
+		name      string
+		alias     string
+		mockSetup func(m *mocks.URLInfoGetter)
+		wantCode  int
+		wantError string
+		want      Response
+		wantETag  string
+	}{
+		{
+			name:  "success",
+			alias: "google",
+			mockSetup: func(m *mocks.URLInfoGetter) {
+				m.On("GetURLInfo", mock.Anything, "google").Return(storage.URL{
+					ID:         7,
+					Alias:      "google",
+					URL:        "https://google.com",
+					CreatedAt:  createdAt,
+					UpdatedAt:  &updatedAt,
+					Version:    2,
+					URLOptions: storage.URLOptions{CreatedBy: "alice"},
+				}, nil)
+			},
+			wantCode: http.StatusOK,
+			want: Response{
+				ID:        7,
+				Alias:     "google",
+				URL:       "https://google.com",
+				CreatedAt: createdAt,
+				UpdatedAt: &updatedAt,
+				CreatedBy: "alice",
+			},
+			wantETag: `"2"`,
+		},
+		{
+			name:  "url not found",
+			alias: "unknown",
+			mockSetup: func(m *mocks.URLInfoGetter) {
+				m.On("GetURLInfo", mock.Anything, "unknown").Return(storage.URL{}, storage.ErrURLNotFound)
+			},
+			wantCode:  http.StatusNotFound,
+			wantError: "not found",
+		},
+		{
+			name:  "internal error",
+			alias: "test",
+			mockSetup: func(m *mocks.URLInfoGetter) {
+				m.On("GetURLInfo", mock.Anything, "test").Return(storage.URL{}, errors.New("db error"))
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantError: "internal error",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockGetter := mocks.NewURLInfoGetter(t)
+			tc.mockSetup(mockGetter)
+
+			r := chi.NewRouter()
+			r.Get("/{alias}", New(slogdiscard.NewDiscardLogger(), mockGetter))
+
+			req := httptest.NewRequest(http.MethodGet, "/"+tc.alias, nil)
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantCode, rec.Code)
+
+			var response Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+			if tc.wantError != "" {
+				assert.Equal(t, tc.wantError, response.Error)
+				return
+			}
+
+			assert.Equal(t, "OK", response.Status)
+			assert.Equal(t, tc.want.ID, response.ID)
+			assert.Equal(t, tc.want.Alias, response.Alias)
+			assert.Equal(t, tc.want.URL, response.URL)
+			assert.True(t, tc.want.CreatedAt.Equal(response.CreatedAt))
+			require.NotNil(t, response.UpdatedAt)
+			assert.True(t, tc.want.UpdatedAt.Equal(*response.UpdatedAt))
+			assert.Equal(t, tc.want.CreatedBy, response.CreatedBy)
+			assert.Equal(t, tc.wantETag, rec.Header().Get("ETag"))
+		})
+	}
+}
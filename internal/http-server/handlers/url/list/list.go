@@ -0,0 +1,199 @@
+package list
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type Response struct {
+	resp.Response
+	URLs       []URL  `json:"urls"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type URL struct {
+	ID        int64      `json:"id"`
+	Alias     string     `json:"alias"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type URLLister interface {
+	ListURLs(ctx context.Context, q storage.ListQuery) ([]storage.URL, error)
+}
+
+// cursor is the decoded form of the opaque next_cursor token. It records the
+// sort order it was issued for so it cannot be replayed against another one.
+type cursor struct {
+	Sort      storage.SortField `json:"s"`
+	Desc      bool              `json:"d,omitempty"`
+	CreatedAt int64             `json:"t,omitempty"`
+	ID        int64             `json:"i,omitempty"`
+	Alias     string            `json:"a,omitempty"`
+}
+
+// New lists links page by page. Results can be narrowed with alias_prefix,
+// url_contains and domain, ordered with sort (created_at or alias) and order
+// (asc or desc), and sized with limit (default: 20). Pass the returned
+// next_cursor as cursor to fetch the following page.
+func New(log *slog.Logger, urlLister URLLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.list.New"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		q, err := parseQuery(r)
+		if err != nil {
+			log.Error("invalid query", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+			return
+		}
+
+		limit := q.Limit
+		// Ask for one extra row to learn whether another page follows.
+		q.Limit++
+
+		urls, err := urlLister.ListURLs(r.Context(), q)
+		if err != nil {
+			log.Error("failed to list urls", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		var next string
+		if len(urls) > limit {
+			urls = urls[:limit]
+			next = encodeCursor(q, urls[len(urls)-1])
+		}
+
+		render.JSON(w, r, Response{
+			Response:   resp.OK(),
+			URLs:       toURLs(urls),
+			NextCursor: next,
+		})
+	}
+}
+
+func parseQuery(r *http.Request) (storage.ListQuery, error) {
+	values := r.URL.Query()
+
+	q := storage.ListQuery{
+		AliasPrefix: values.Get("alias_prefix"),
+		URLContains: values.Get("url_contains"),
+		Domain:      values.Get("domain"),
+		SortBy:      storage.SortByCreatedAt,
+		Limit:       defaultLimit,
+	}
+
+	switch s := storage.SortField(values.Get("sort")); s {
+	case "":
+	case storage.SortByCreatedAt, storage.SortByAlias:
+		q.SortBy = s
+	default:
+		return q, errors.New("field sort must be one of created_at, alias")
+	}
+
+	// Newest links come first unless asked otherwise; aliases read best A to Z.
+	q.Desc = q.SortBy == storage.SortByCreatedAt
+	switch values.Get("order") {
+	case "":
+	case "asc":
+		q.Desc = false
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errors.New("field order must be one of asc, desc")
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return q, fmt.Errorf("field limit must be between 1 and %d", maxLimit)
+		}
+		q.Limit = limit
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		after, err := decodeCursor(v, q)
+		if err != nil {
+			return q, errors.New("field cursor is invalid")
+		}
+		q.After = after
+	}
+
+	return q, nil
+}
+
+func encodeCursor(q storage.ListQuery, last storage.URL) string {
+	c := cursor{Sort: q.SortBy, Desc: q.Desc}
+	if q.SortBy == storage.SortByAlias {
+		c.Alias = last.Alias
+	} else {
+		c.CreatedAt = last.CreatedAt.UnixMicro()
+		c.ID = last.ID
+	}
+
+	// Marshalling a struct of plain fields cannot fail.
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string, q storage.ListQuery) (*storage.ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	if c.Sort != q.SortBy || c.Desc != q.Desc {
+		return nil, errors.New("cursor was issued for a different order")
+	}
+
+	return &storage.ListCursor{
+		CreatedAt: time.UnixMicro(c.CreatedAt).UTC(),
+		ID:        c.ID,
+		Alias:     c.Alias,
+	}, nil
+}
+
+func toURLs(urls []storage.URL) []URL {
+	out := make([]URL, 0, len(urls))
+	for _, u := range urls {
+		out = append(out, URL{
+			ID:        u.ID,
+			Alias:     u.Alias,
+			URL:       u.URL,
+			CreatedAt: u.CreatedAt,
+			ExpiresAt: u.ExpiresAt,
+		})
+	}
+
+	return out
+}
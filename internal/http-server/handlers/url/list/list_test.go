@@ -0,0 +1,182 @@
+package list
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"urlShortener/internal/http-server/handlers/url/list/mocks"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListHandler(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	links := []storage.URL{
+		{ID: 3, Alias: "c", URL: "https://c.example.com", CreatedAt: created.Add(2 * time.Second)},
+		{ID: 2, Alias: "b", URL: "https://b.example.com", CreatedAt: created.Add(time.Second)},
+		{ID: 1, Alias: "a", URL: "https://a.example.com", CreatedAt: created},
+	}
+
+	aliasCursor := encodeCursor(storage.ListQuery{SortBy: storage.SortByAlias}, storage.URL{Alias: "b"})
+
+	cases := []struct {
+		name        string
+		query       string
+		mockSetup   func(m *mocks.URLLister)
+		wantCode    int
+		wantError   string
+		wantAliases []string
+		wantNext    bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			mockSetup: func(m *mocks.URLLister) {
+				q := storage.ListQuery{SortBy: storage.SortByCreatedAt, Desc: true, Limit: defaultLimit + 1}
+				m.On("ListURLs", mock.Anything, q).Return(links, nil)
+			},
+			wantCode:    http.StatusOK,
+			wantAliases: []string{"c", "b", "a"},
+		},
+		{
+			name:  "filters",
+			query: "?alias_prefix=go&url_contains=Search&domain=google.com&sort=alias&order=desc",
+			mockSetup: func(m *mocks.URLLister) {
+				q := storage.ListQuery{
+					AliasPrefix: "go",
+					URLContains: "Search",
+					Domain:      "google.com",
+					SortBy:      storage.SortByAlias,
+					Desc:        true,
+					Limit:       defaultLimit + 1,
+				}
+				m.On("ListURLs", mock.Anything, q).Return(nil, nil)
+			},
+			wantCode:    http.StatusOK,
+			wantAliases: []string{},
+		},
+		{
+			name:  "next page",
+			query: "?limit=2",
+			mockSetup: func(m *mocks.URLLister) {
+				m.On("ListURLs", mock.Anything, mock.MatchedBy(func(q storage.ListQuery) bool {
+					return q.Limit == 3
+				})).Return(links, nil)
+			},
+			wantCode:    http.StatusOK,
+			wantAliases: []string{"c", "b"},
+			wantNext:    true,
+		},
+		{
+			name:  "with cursor",
+			query: "?sort=alias&cursor=" + aliasCursor,
+			mockSetup: func(m *mocks.URLLister) {
+				m.On("ListURLs", mock.Anything, mock.MatchedBy(func(q storage.ListQuery) bool {
+					return q.After != nil && q.After.Alias == "b"
+				})).Return(links[:1], nil)
+			},
+			wantCode:    http.StatusOK,
+			wantAliases: []string{"c"},
+		},
+		{
+			name:      "cursor for another order",
+			query:     "?cursor=" + aliasCursor,
+			mockSetup: func(m *mocks.URLLister) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field cursor is invalid",
+		},
+		{
+			name:      "garbage cursor",
+			query:     "?cursor=!!!",
+			mockSetup: func(m *mocks.URLLister) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field cursor is invalid",
+		},
+		{
+			name:      "invalid sort",
+			query:     "?sort=url",
+			mockSetup: func(m *mocks.URLLister) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field sort must be one of created_at, alias",
+		},
+		{
+			name:      "invalid order",
+			query:     "?order=up",
+			mockSetup: func(m *mocks.URLLister) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field order must be one of asc, desc",
+		},
+		{
+			name:      "invalid limit",
+			query:     "?limit=101",
+			mockSetup: func(m *mocks.URLLister) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field limit must be between 1 and 100",
+		},
+		{
+			name:  "internal error",
+			query: "",
+			mockSetup: func(m *mocks.URLLister) {
+				m.On("ListURLs", mock.Anything, mock.Anything).Return(nil, errors.New("db error"))
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantError: "internal error",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockLister := mocks.NewURLLister(t)
+			tc.mockSetup(mockLister)
+
+			r := chi.NewRouter()
+			r.Get("/", New(slogdiscard.NewDiscardLogger(), mockLister))
+
+			req := httptest.NewRequest(http.MethodGet, "/"+tc.query, nil)
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantCode, rec.Code)
+
+			var response Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+			if tc.wantError != "" {
+				assert.Equal(t, "Error", response.Status)
+				assert.Equal(t, tc.wantError, response.Error)
+				return
+			}
+
+			assert.Equal(t, "OK", response.Status)
+
+			aliases := []string{}
+			for _, u := range response.URLs {
+				aliases = append(aliases, u.Alias)
+			}
+			assert.Equal(t, tc.wantAliases, aliases)
+			assert.Equal(t, tc.wantNext, response.NextCursor != "")
+		})
+	}
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	q := storage.ListQuery{SortBy: storage.SortByCreatedAt, Desc: true}
+	last := storage.URL{ID: 7, Alias: "x", CreatedAt: time.Date(2026, 1, 1, 0, 0, 0, 123456000, time.UTC)}
+
+	after, err := decodeCursor(encodeCursor(q, last), q)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(7), after.ID)
+	assert.True(t, last.CreatedAt.Equal(after.CreatedAt))
+}
@@ -2,16 +2,50 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+
+	storage "urlShortener/internal/storage"
+)
 
 // URLSaver is an autogenerated mock type for the URLSaver type
 type URLSaver struct {
 	mock.Mock
 }
 
-// SaveURL provides a mock function with given fields: urlToSave, alias
-func (_m *URLSaver) SaveURL(urlToSave string, alias string) (int64, error) {
-	ret := _m.Called(urlToSave, alias)
+// GetURLByDestination provides a mock function with given fields: ctx, urlToSave
+func (_m *URLSaver) GetURLByDestination(ctx context.Context, urlToSave string) (storage.URL, error) {
+	ret := _m.Called(ctx, urlToSave)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetURLByDestination")
+	}
+
+	var r0 storage.URL
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (storage.URL, error)); ok {
+		return rf(ctx, urlToSave)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.URL); ok {
+		r0 = rf(ctx, urlToSave)
+	} else {
+		r0 = ret.Get(0).(storage.URL)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, urlToSave)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveURL provides a mock function with given fields: ctx, urlToSave, alias, opts
+func (_m *URLSaver) SaveURL(ctx context.Context, urlToSave string, alias string, opts storage.URLOptions) (int64, error) {
+	ret := _m.Called(ctx, urlToSave, alias, opts)
 
 	if len(ret) == 0 {
 		panic("no return value specified for SaveURL")
@@ -19,17 +53,17 @@ func (_m *URLSaver) SaveURL(urlToSave string, alias string) (int64, error) {
 
 	var r0 int64
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string, string) (int64, error)); ok {
-		return rf(urlToSave, alias)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, storage.URLOptions) (int64, error)); ok {
+		return rf(ctx, urlToSave, alias, opts)
 	}
-	if rf, ok := ret.Get(0).(func(string, string) int64); ok {
-		r0 = rf(urlToSave, alias)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, storage.URLOptions) int64); ok {
+		r0 = rf(ctx, urlToSave, alias, opts)
 	} else {
 		r0 = ret.Get(0).(int64)
 	}
 
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(urlToSave, alias)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, storage.URLOptions) error); ok {
+		r1 = rf(ctx, urlToSave, alias, opts)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -1,11 +1,17 @@
 package save
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"regexp"
+	"time"
 	resp "urlShortener/internal/lib/api/response"
 	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/lib/metrics"
+	"urlShortener/internal/lib/password"
 	"urlShortener/internal/lib/random"
 	"urlShortener/internal/storage"
 
@@ -16,25 +22,124 @@ import (
 
 type Request struct {
 	URL   string `json:"url" validate:"required,url"`
-	Alias string `json:"alias,omitempty"`
+	Alias string `json:"alias,omitempty" validate:"omitempty,alias"`
+	// ExpiresIn is the link lifetime in seconds.
+	ExpiresIn int64      `json:"expires_in,omitempty" validate:"omitempty,gt=0,excluded_with=ExpiresAt"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" validate:"omitempty,gt"`
+	// Deduplicate overrides the server default for reusing an existing link
+	// to the same destination. It only applies to POST /url.
+	Deduplicate *bool `json:"deduplicate,omitempty"`
+	// RedirectStatus overrides the server default redirect status.
+	RedirectStatus int `json:"redirect_status,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+	// Passthrough forwards the query string and trailing path of visits.
+	Passthrough bool `json:"passthrough,omitempty"`
+	// UTM and the named UTMTemplate are appended to the destination when
+	// the link is followed. Fields set in UTM override the template's.
+	UTM         UTM    `json:"utm,omitempty"`
+	UTMTemplate string `json:"utm_template,omitempty" validate:"omitempty,max=64"`
+	// Password, if set, must be entered by visitors before they are
+	// redirected. Only its hash is stored.
+	Password string `json:"password,omitempty" validate:"omitempty,max=72"`
+	// MaxClicks makes the link stop working after that many visits.
+	MaxClicks int64 `json:"max_clicks,omitempty" validate:"omitempty,gt=0"`
+	// NotBefore holds the link back until then. Visits before go to
+	// FallbackURL, or get the server's "not yet active" response.
+	NotBefore   *time.Time `json:"not_before,omitempty" validate:"omitempty,gt"`
+	FallbackURL string     `json:"fallback_url,omitempty" validate:"omitempty,url,excluded_without=NotBefore"`
+	// Targets send visits from matching platforms elsewhere; the first
+	// match wins and URL is the default.
+	Targets []Target `json:"targets,omitempty" validate:"max=10,dive"`
+	// CountryTargets do the same by the visitor's country, after Targets.
+	CountryTargets []CountryTarget `json:"country_targets,omitempty" validate:"max=10,dive"`
+}
+
+// Target is the JSON form of storage.Target. Platform is ios, android,
+// desktop, or regex to match Pattern against the User-Agent header.
+type Target struct {
+	Platform string `json:"platform" validate:"required,oneof=ios android desktop regex"`
+	Pattern  string `json:"pattern,omitempty" validate:"required_if=Platform regex,excluded_unless=Platform regex,max=256"`
+	URL      string `json:"url" validate:"required,url"`
+}
+
+// NewTargets converts stored targets to their JSON form.
+func NewTargets(targets []storage.Target) []Target {
+	res := make([]Target, 0, len(targets))
+	for _, t := range targets {
+		res = append(res, Target(t))
+	}
+	return res
+}
+
+// CountryTarget is the JSON form of storage.CountryTarget. Countries are
+// upper case ISO 3166-1 alpha-2 codes such as "DE".
+type CountryTarget struct {
+	Countries []string `json:"countries" validate:"required,min=1,max=50,dive,iso3166_1_alpha2"`
+	URL       string   `json:"url" validate:"required,url"`
+}
+
+// NewCountryTargets converts stored country targets to their JSON form.
+func NewCountryTargets(targets []storage.CountryTarget) []CountryTarget {
+	res := make([]CountryTarget, 0, len(targets))
+	for _, t := range targets {
+		res = append(res, CountryTarget(t))
+	}
+	return res
+}
+
+// LogValue keeps the password out of the logs.
+func (req Request) LogValue() slog.Value {
+	type plain Request
+	if req.Password != "" {
+		req.Password = "[redacted]"
+	}
+	return slog.AnyValue(plain(req))
+}
+
+// UTM is the JSON form of storage.UTM.
+type UTM struct {
+	Source   string `json:"source,omitempty" validate:"omitempty,max=256"`
+	Medium   string `json:"medium,omitempty" validate:"omitempty,max=256"`
+	Campaign string `json:"campaign,omitempty" validate:"omitempty,max=256"`
+	Term     string `json:"term,omitempty" validate:"omitempty,max=256"`
+	Content  string `json:"content,omitempty" validate:"omitempty,max=256"`
+}
+
+// NewUTM converts stored UTM parameters to their JSON form.
+func NewUTM(u storage.UTM) UTM {
+	return UTM(u)
+}
+
+// Storage converts u to its storage form.
+func (u UTM) Storage() storage.UTM {
+	return storage.UTM(u)
 }
 
 type Response struct {
 	resp.Response
-	Alias string `json:"alias,omitempty"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Existing is set when an earlier link was returned instead of a new one.
+	Existing bool `json:"existing,omitempty"`
 }
 
-const aliasLength = 6
-
 type URLSaver interface {
-	SaveURL(urlToSave string, alias string) (int64, error)
+	SaveURL(ctx context.Context, urlToSave string, alias string, opts storage.URLOptions) (int64, error)
+	GetURLByDestination(ctx context.Context, urlToSave string) (storage.URL, error)
 }
 
-func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
+// New creates a link. Requests are checked with validate, which must know
+// the aliasrule tag. Requests without an alias get one from aliases, which
+// is retried while the generated aliases turn out to be taken.
+//
+// With deduplication on, by default or per request, a request that asks for
+// nothing but a link to its URL, as Request.plain decides, returns the alias
+// of an existing link to the same normalized destination that has no options
+// either, instead of creating a new one.
+func New(log *slog.Logger, urlSaver URLSaver, aliases random.AliasSource, validate *validator.Validate, deduplicate bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.save.New"
 
-		log = log.With(slog.String("op", op),
+		log := log.With(slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())))
 
 		var req Request
@@ -49,7 +154,7 @@ func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
 
 		log.Info("request body decoded", slog.Any("request", req))
 
-		if err := validator.New().Struct(req); err != nil {
+		if err := validate.Struct(req); err != nil {
 			validateErr := err.(validator.ValidationErrors)
 
 			log.Error("invalid request", sl.Err(err))
@@ -58,18 +163,57 @@ func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
 			return
 		}
 
-		alias := req.Alias
-		if alias == "" {
-			alias = random.NewRandomString(aliasLength)
+		if req.plain() && (req.Deduplicate == nil && deduplicate || req.Deduplicate != nil && *req.Deduplicate) {
+			existing, err := urlSaver.GetURLByDestination(r.Context(), req.URL)
+			if err == nil {
+				log.Info("existing url returned", slog.String("alias", existing.Alias))
+
+				render.JSON(w, r, Response{
+					Response: resp.OK(),
+					Alias:    existing.Alias,
+					Existing: true,
+				})
+				return
+			}
+
+			if !errors.Is(err, storage.ErrURLNotFound) {
+				log.Error("failed to look up existing url", sl.Err(err))
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, resp.Error("failed to add url"))
+				return
+			}
 		}
 
-		id, err := urlSaver.SaveURL(req.URL, alias)
-		if errors.Is(err, storage.ErrURLExists) {
-			log.Error("alias already exists", slog.String("alias", alias))
-			render.Status(r, http.StatusConflict)
-			render.JSON(w, r, resp.Error("alias already exists"))
+		// The /url group sits behind basic auth, so the user name is the creator.
+		creator, _, _ := r.BasicAuth()
+		opts, err := req.Options(creator)
+		if msg := OptionsError(err); msg != "" {
+			log.Error("invalid request", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(msg))
 			return
 		}
+		if err != nil {
+			log.Error("failed to build url options", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to add url"))
+			return
+		}
+
+		alias := req.Alias
+		var id int64
+
+		if alias != "" {
+			id, err = urlSaver.SaveURL(r.Context(), req.URL, alias, opts)
+			if errors.Is(err, storage.ErrURLExists) {
+				log.Error("alias already exists", slog.String("alias", alias))
+				render.Status(r, http.StatusConflict)
+				render.JSON(w, r, resp.Error("alias already exists"))
+				return
+			}
+		} else {
+			alias, id, err = saveGenerated(r.Context(), log, urlSaver, req.URL, opts, aliases)
+		}
 
 		if err != nil {
 			log.Error("failed to add url", sl.Err(err))
@@ -81,8 +225,118 @@ func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
 		log.Info("url added", slog.Int64("id", id))
 
 		render.JSON(w, r, Response{
-			Response: resp.OK(),
-			Alias:    alias,
+			Response:  resp.OK(),
+			Alias:     alias,
+			ExpiresAt: opts.ExpiresAt,
 		})
 	}
 }
+
+// saveGenerated saves the link under the first free alias drawn from aliases.
+func saveGenerated(
+	ctx context.Context,
+	log *slog.Logger,
+	urlSaver URLSaver,
+	urlToSave string,
+	opts storage.URLOptions,
+	aliases random.AliasSource,
+) (string, int64, error) {
+	for attempt := 0; attempt < aliases.MaxAttempts; attempt++ {
+		alias, err := aliases.Alias(ctx, attempt)
+		if err != nil {
+			return "", 0, err
+		}
+
+		id, err := urlSaver.SaveURL(ctx, urlToSave, alias, opts)
+		if !errors.Is(err, storage.ErrURLExists) {
+			return alias, id, err
+		}
+
+		metrics.AliasCollisions.Add(1)
+		log.Warn("generated alias already exists", slog.String("alias", alias), slog.Int("attempt", attempt+1))
+	}
+
+	metrics.AliasExhausted.Add(1)
+
+	return "", 0, fmt.Errorf("no free alias after %d attempts", aliases.MaxAttempts)
+}
+
+// ErrEmptyWindow is returned by Request.Options for links that would
+// expire before they become active.
+var ErrEmptyWindow = errors.New("link expires before it becomes active")
+
+// ErrInvalidPattern is returned by Request.Options for regex targets whose
+// pattern does not compile.
+var ErrInvalidPattern = errors.New("invalid target pattern")
+
+// OptionsError returns the message for clients whose request made
+// Request.Options fail, or "" if the failure is not theirs.
+func OptionsError(err error) string {
+	switch {
+	case errors.Is(err, password.ErrTooLong):
+		return "field Password must be at most 72 bytes long"
+	case errors.Is(err, ErrEmptyWindow):
+		return "field NotBefore must be before the expiry"
+	case errors.Is(err, ErrInvalidPattern):
+		return "field Pattern is not a valid regular expression"
+	default:
+		return ""
+	}
+}
+
+// plain reports whether req asks for nothing but a link to its URL, so that
+// an existing one can stand in for it.
+func (req Request) plain() bool {
+	return req.Alias == "" && req.ExpiresIn == 0 && req.ExpiresAt == nil && req.Password == "" &&
+		req.MaxClicks == 0 && req.NotBefore == nil && len(req.Targets) == 0 && len(req.CountryTargets) == 0 &&
+		req.RedirectStatus == 0 && !req.Passthrough && req.UTM.Storage().Empty() && req.UTMTemplate == ""
+}
+
+// Options returns the storage options req asks for on behalf of creator,
+// hashing its password. Errors OptionsError knows are the client's fault.
+func (req Request) Options(creator string) (storage.URLOptions, error) {
+	opts := storage.URLOptions{
+		CreatedBy:      creator,
+		RedirectStatus: req.RedirectStatus,
+		Passthrough:    req.Passthrough,
+		UTM:            req.UTM.Storage(),
+		UTMTemplate:    req.UTMTemplate,
+		MaxClicks:      req.MaxClicks,
+		NotBefore:      req.NotBefore,
+		FallbackURL:    req.FallbackURL,
+	}
+	switch {
+	case req.ExpiresAt != nil:
+		opts.ExpiresAt = req.ExpiresAt
+	case req.ExpiresIn > 0:
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second).UTC().Truncate(time.Second)
+		opts.ExpiresAt = &expiresAt
+	}
+
+	for _, t := range req.Targets {
+		if t.Platform == storage.TargetRegex {
+			if _, err := regexp.Compile(t.Pattern); err != nil {
+				return opts, fmt.Errorf("%w %q: %w", ErrInvalidPattern, t.Pattern, err)
+			}
+		}
+		opts.Targets = append(opts.Targets, storage.Target(t))
+	}
+
+	for _, t := range req.CountryTargets {
+		opts.CountryTargets = append(opts.CountryTargets, storage.CountryTarget(t))
+	}
+
+	if opts.NotBefore != nil && opts.ExpiresAt != nil && !opts.NotBefore.Before(*opts.ExpiresAt) {
+		return opts, ErrEmptyWindow
+	}
+
+	if req.Password != "" {
+		hash, err := password.Hash(req.Password)
+		if err != nil {
+			return opts, err
+		}
+		opts.PasswordHash = hash
+	}
+
+	return opts, nil
+}
@@ -2,13 +2,20 @@ package save
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"urlShortener/internal/http-server/handlers/url/save/mocks"
+	"urlShortener/internal/lib/aliasrule"
+	"urlShortener/internal/lib/password"
+	"urlShortener/internal/lib/random"
 	"urlShortener/internal/lib/slogdiscard"
 	"urlShortener/internal/storage"
 
@@ -21,6 +28,8 @@ func TestSaveHandler(t *testing.T) {
 	cases := []struct {
 		name       string
 		body       string
+		user       string
+		dedupe     bool
 		mockSetup  func(m *mocks.URLSaver)
 		wantCode   int
 		wantStatus string
@@ -31,7 +40,7 @@ func TestSaveHandler(t *testing.T) {
 			name: "success with custom alias",
 			body: `{"url": "https://google.com", "alias": "google"}`,
 			mockSetup: func(m *mocks.URLSaver) {
-				m.On("SaveURL", "https://google.com", "google").Return(int64(1), nil)
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", storage.URLOptions{}).Return(int64(1), nil)
 			},
 			wantCode:   http.StatusOK,
 			wantStatus: "OK",
@@ -41,16 +50,199 @@ func TestSaveHandler(t *testing.T) {
 			name: "success with generated alias",
 			body: `{"url": "https://google.com"}`,
 			mockSetup: func(m *mocks.URLSaver) {
-				m.On("SaveURL", "https://google.com", mock.AnythingOfType("string")).Return(int64(1), nil)
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{}).Return(int64(1), nil)
 			},
 			wantCode:   http.StatusOK,
 			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name: "generated alias taken is retried",
+			body: `{"url": "https://google.com"}`,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{}).Return(int64(0), storage.ErrURLExists)
+				m.On("SaveURL", mock.Anything, "https://google.com", "2", storage.URLOptions{}).Return(int64(2), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "2",
+		},
+		{
+			name: "no free generated alias",
+			body: `{"url": "https://google.com"}`,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", mock.Anything, storage.URLOptions{}).Return(int64(0), storage.ErrURLExists).Times(3)
+			},
+			wantCode:   http.StatusInternalServerError,
+			wantStatus: "Error",
+			wantError:  "failed to add url",
+		},
+		{
+			name:   "deduplicate returns existing link",
+			body:   `{"url": "https://google.com"}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("GetURLByDestination", mock.Anything, "https://google.com").Return(storage.URL{Alias: "goog"}, nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "goog",
+		},
+		{
+			name:   "deduplicate without existing link",
+			body:   `{"url": "https://google.com"}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("GetURLByDestination", mock.Anything, "https://google.com").Return(storage.URL{}, storage.ErrURLNotFound)
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name: "deduplicate per request",
+			body: `{"url": "https://google.com", "deduplicate": true}`,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("GetURLByDestination", mock.Anything, "https://google.com").Return(storage.URL{Alias: "goog"}, nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "goog",
+		},
+		{
+			name:   "deduplicate turned off per request",
+			body:   `{"url": "https://google.com", "deduplicate": false}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name:   "deduplicate skipped for custom alias",
+			body:   `{"url": "https://google.com", "alias": "google"}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", storage.URLOptions{}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "google",
+		},
+		{
+			name:   "deduplicate skipped for password",
+			body:   `{"url": "https://google.com", "password": "s3cret"}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", mock.Anything).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name:   "deduplicate skipped for redirect status",
+			body:   `{"url": "https://google.com", "redirect_status": 308}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{RedirectStatus: 308}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name:   "deduplicate skipped for passthrough",
+			body:   `{"url": "https://google.com", "passthrough": true}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{Passthrough: true}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name:   "deduplicate skipped for utm",
+			body:   `{"url": "https://google.com", "utm": {"source": "mail"}}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{UTM: storage.UTM{Source: "mail"}}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name:   "deduplicate skipped for utm template",
+			body:   `{"url": "https://google.com", "utm_template": "mail"}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "1", storage.URLOptions{UTMTemplate: "mail"}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "1",
+		},
+		{
+			name:   "deduplicate lookup error",
+			body:   `{"url": "https://google.com"}`,
+			dedupe: true,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("GetURLByDestination", mock.Anything, "https://google.com").Return(storage.URL{}, errors.New("db error"))
+			},
+			wantCode:   http.StatusInternalServerError,
+			wantStatus: "Error",
+			wantError:  "failed to add url",
+		},
+		{
+			name:       "alias too short",
+			body:       `{"url": "https://google.com", "alias": "go"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Alias must be at least 3 characters long",
+		},
+		{
+			name:       "alias too long",
+			body:       `{"url": "https://google.com", "alias": "googlegoogle"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Alias must be at most 10 characters long",
+		},
+		{
+			name:       "alias with slash",
+			body:       `{"url": "https://google.com", "alias": "go/ogle"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Alias may only contain the characters a-z0-9-",
+		},
+		{
+			name:       "reserved alias",
+			body:       `{"url": "https://google.com", "alias": "url"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Alias is a reserved word",
+		},
+		{
+			name:       "denied word in alias",
+			body:       `{"url": "https://google.com", "alias": "go-darn-it"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Alias contains a word that is not allowed",
 		},
 		{
 			name: "alias already exists",
 			body: `{"url": "https://google.com", "alias": "google"}`,
 			mockSetup: func(m *mocks.URLSaver) {
-				m.On("SaveURL", "https://google.com", "google").Return(int64(0), storage.ErrURLExists)
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", storage.URLOptions{}).Return(int64(0), storage.ErrURLExists)
 			},
 			wantCode:   http.StatusConflict,
 			wantStatus: "Error",
@@ -60,12 +252,167 @@ func TestSaveHandler(t *testing.T) {
 			name: "save error",
 			body: `{"url": "https://google.com", "alias": "google"}`,
 			mockSetup: func(m *mocks.URLSaver) {
-				m.On("SaveURL", "https://google.com", "google").Return(int64(0), errors.New("unexpected error"))
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", storage.URLOptions{}).Return(int64(0), errors.New("unexpected error"))
 			},
 			wantCode:   http.StatusInternalServerError,
 			wantStatus: "Error",
 			wantError:  "failed to add url",
 		},
+		{
+			name: "success with expires_at",
+			body: `{"url": "https://google.com", "alias": "google", "expires_at": "2100-01-02T15:04:05Z"}`,
+			mockSetup: func(m *mocks.URLSaver) {
+				expiresAt := time.Date(2100, 1, 2, 15, 4, 5, 0, time.UTC)
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", storage.URLOptions{ExpiresAt: &expiresAt}).
+					Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "google",
+		},
+		{
+			name: "success with expires_in",
+			body: `{"url": "https://google.com", "alias": "google", "expires_in": 3600}`,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", mock.MatchedBy(func(opts storage.URLOptions) bool {
+					return opts.ExpiresAt != nil && time.Until(*opts.ExpiresAt) > 59*time.Minute
+				})).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "google",
+		},
+		{
+			name: "records creator",
+			body: `{"url": "https://google.com", "alias": "google"}`,
+			user: "alice",
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", storage.URLOptions{CreatedBy: "alice"}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "google",
+		},
+		{
+			name: "password is hashed",
+			body: `{"url": "https://google.com", "alias": "google", "password": "s3cret"}`,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", mock.MatchedBy(func(opts storage.URLOptions) bool {
+					return opts.PasswordHash != "s3cret" && password.Check(opts.PasswordHash, "s3cret")
+				})).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "google",
+		},
+		{
+			name:       "password over 72 bytes",
+			body:       `{"url": "https://google.com", "password": "` + strings.Repeat("ä", 40) + `"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Password must be at most 72 bytes long",
+		},
+		{
+			name:       "expires_at in the past",
+			body:       `{"url": "https://google.com", "expires_at": "2000-01-01T00:00:00Z"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field ExpiresAt must be in the future",
+		},
+		{
+			name:       "negative expires_in",
+			body:       `{"url": "https://google.com", "expires_in": -5}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field ExpiresIn must be greater than 0",
+		},
+		{
+			name:       "expires_in with expires_at",
+			body:       `{"url": "https://google.com", "expires_in": 60, "expires_at": "2100-01-01T00:00:00Z"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field ExpiresIn cannot be used together with ExpiresAt",
+		},
+		{
+			name:       "not_before after expiry",
+			body:       `{"url": "https://google.com", "not_before": "2100-01-02T00:00:00Z", "expires_at": "2100-01-01T00:00:00Z"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field NotBefore must be before the expiry",
+		},
+		{
+			name:       "fallback_url without not_before",
+			body:       `{"url": "https://google.com", "fallback_url": "https://google.com/soon"}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field FallbackURL can only be used together with NotBefore",
+		},
+		{
+			name:       "target with unknown platform",
+			body:       `{"url": "https://google.com", "targets": [{"platform": "windows", "url": "https://google.com/win"}]}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Platform must be one of ios android desktop regex",
+		},
+		{
+			name:       "regex target without pattern",
+			body:       `{"url": "https://google.com", "targets": [{"platform": "regex", "url": "https://google.com/x"}]}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Pattern is a required field",
+		},
+		{
+			name:       "pattern on platform target",
+			body:       `{"url": "https://google.com", "targets": [{"platform": "ios", "pattern": "x", "url": "https://google.com/x"}]}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Pattern can only be used when Platform is regex",
+		},
+		{
+			name:       "invalid target pattern",
+			body:       `{"url": "https://google.com", "targets": [{"platform": "regex", "pattern": "(", "url": "https://google.com/x"}]}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Pattern is not a valid regular expression",
+		},
+		{
+			name: "country targets",
+			body: `{"url": "https://google.com", "alias": "google", "country_targets": [{"countries": ["DE", "AT"], "url": "https://google.de"}]}`,
+			mockSetup: func(m *mocks.URLSaver) {
+				m.On("SaveURL", mock.Anything, "https://google.com", "google", storage.URLOptions{
+					CountryTargets: []storage.CountryTarget{{Countries: []string{"DE", "AT"}, URL: "https://google.de"}},
+				}).Return(int64(1), nil)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "OK",
+			wantAlias:  "google",
+		},
+		{
+			name:       "country target with unknown country",
+			body:       `{"url": "https://google.com", "country_targets": [{"countries": ["de"], "url": "https://google.de"}]}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Countries[0] must be an ISO 3166-1 alpha-2 country code",
+		},
+		{
+			name:       "country target without countries",
+			body:       `{"url": "https://google.com", "country_targets": [{"countries": [], "url": "https://google.de"}]}`,
+			mockSetup:  func(m *mocks.URLSaver) {},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "Error",
+			wantError:  "field Countries must have at least 1 entries",
+		},
 		{
 			name:       "invalid json",
 			body:       `{"url": "https://google.com"`,
@@ -97,10 +444,23 @@ func TestSaveHandler(t *testing.T) {
 			mockSaver := mocks.NewURLSaver(t)
 			tc.mockSetup(mockSaver)
 
-			handler := New(slogdiscard.NewDiscardLogger(), mockSaver)
+			aliases := random.AliasSource{Generator: random.NewSequentialGenerator(counter()), MaxAttempts: 3}
+			validate, err := aliasrule.NewValidator(aliasrule.Rules{
+				MinLength: 3,
+				MaxLength: 10,
+				Charset:   "a-z0-9-",
+				Reserved:  []string{"url"},
+				Denied:    []string{"darn"},
+			})
+			require.NoError(t, err)
+
+			handler := New(slogdiscard.NewDiscardLogger(), mockSaver, aliases, validate, tc.dedupe)
 
 			req := httptest.NewRequest(http.MethodPost, "/url", bytes.NewBufferString(tc.body))
 			req.Header.Set("Content-Type", "application/json")
+			if tc.user != "" {
+				req.SetBasicAuth(tc.user, "secret")
+			}
 			rec := httptest.NewRecorder()
 
 			handler(rec, req)
@@ -108,7 +468,7 @@ func TestSaveHandler(t *testing.T) {
 			require.Equal(t, tc.wantCode, rec.Code)
 
 			var resp Response
-			err := json.Unmarshal(rec.Body.Bytes(), &resp)
+			err = json.Unmarshal(rec.Body.Bytes(), &resp)
 			require.NoError(t, err)
 
 			assert.Equal(t, tc.wantStatus, resp.Status)
@@ -120,11 +480,15 @@ func TestSaveHandler(t *testing.T) {
 			if tc.wantAlias != "" {
 				assert.Equal(t, tc.wantAlias, resp.Alias)
 			}
-
-			if tc.name == "success with generated alias" {
-				assert.NotEmpty(t, resp.Alias)
-				assert.Len(t, resp.Alias, aliasLength)
-			}
 		})
 	}
 }
+
+// counter counts up from 1, as the database sequence does.
+func counter() random.Counter {
+	var next atomic.Int64
+
+	return func(context.Context) (int64, error) {
+		return next.Add(1), nil
+	}
+}
@@ -0,0 +1,176 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultRange = 30 * 24 * time.Hour
+	defaultTop   = 10
+	maxTop       = 100
+)
+
+type Response struct {
+	resp.Response
+	Alias         string        `json:"alias"`
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	Bucket        string        `json:"bucket"`
+	TotalClicks   int64         `json:"total_clicks"`
+	UniqueClicks  int64         `json:"unique_clicks"`
+	Series        []SeriesPoint `json:"series"`
+	TopReferrers  []TopValue    `json:"top_referrers"`
+	TopUserAgents []TopValue    `json:"top_user_agents"`
+}
+
+type SeriesPoint struct {
+	Time   time.Time `json:"time"`
+	Clicks int64     `json:"clicks"`
+}
+
+type TopValue struct {
+	Value  string `json:"value"`
+	Clicks int64  `json:"clicks"`
+}
+
+type ClickStatsGetter interface {
+	ClickStats(ctx context.Context, alias string, q storage.StatsQuery) (storage.ClickStats, error)
+}
+
+// New returns click statistics for an alias. The range is set by the from
+// and to query parameters (RFC 3339, default: the last 30 days), the series
+// step by bucket (hour, day or week, default: day) and the ranking length by
+// top (default: 10).
+func New(log *slog.Logger, statsGetter ClickStatsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.stats.New"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		alias := chi.URLParam(r, "alias")
+		if alias == "" {
+			log.Error("alias is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid request"))
+			return
+		}
+
+		q, err := parseQuery(r)
+		if err != nil {
+			log.Error("invalid query", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+			return
+		}
+
+		stats, err := statsGetter.ClickStats(r.Context(), alias, q)
+		if errors.Is(err, storage.ErrURLNotFound) {
+			log.Info("url not found", "alias", alias)
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("not found"))
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to get stats", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response:      resp.OK(),
+			Alias:         alias,
+			From:          q.From,
+			To:            q.To,
+			Bucket:        string(q.Bucket),
+			TotalClicks:   stats.Total,
+			UniqueClicks:  stats.Unique,
+			Series:        toSeries(stats.Series),
+			TopReferrers:  toTopValues(stats.TopReferrers),
+			TopUserAgents: toTopValues(stats.TopUserAgents),
+		})
+	}
+}
+
+func parseQuery(r *http.Request) (storage.StatsQuery, error) {
+	values := r.URL.Query()
+
+	q := storage.StatsQuery{
+		To:     time.Now().UTC(),
+		Bucket: storage.BucketDay,
+		Top:    defaultTop,
+	}
+
+	if v := values.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.New("field to must be an RFC 3339 timestamp")
+		}
+		q.To = to.UTC()
+	}
+
+	q.From = q.To.Add(-defaultRange)
+	if v := values.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.New("field from must be an RFC 3339 timestamp")
+		}
+		q.From = from.UTC()
+	}
+
+	if !q.From.Before(q.To) {
+		return q, errors.New("field from must be before to")
+	}
+
+	if v := values.Get("bucket"); v != "" {
+		switch b := storage.Bucket(v); b {
+		case storage.BucketHour, storage.BucketDay, storage.BucketWeek:
+			q.Bucket = b
+		default:
+			return q, errors.New("field bucket must be one of hour, day, week")
+		}
+	}
+
+	if v := values.Get("top"); v != "" {
+		top, err := strconv.Atoi(v)
+		if err != nil || top < 1 || top > maxTop {
+			return q, fmt.Errorf("field top must be between 1 and %d", maxTop)
+		}
+		q.Top = top
+	}
+
+	return q, nil
+}
+
+func toSeries(buckets []storage.BucketCount) []SeriesPoint {
+	series := make([]SeriesPoint, 0, len(buckets))
+	for _, b := range buckets {
+		series = append(series, SeriesPoint{Time: b.Start, Clicks: b.Clicks})
+	}
+
+	return series
+}
+
+func toTopValues(values []storage.ValueCount) []TopValue {
+	top := make([]TopValue, 0, len(values))
+	for _, v := range values {
+		top = append(top, TopValue{Value: v.Value, Clicks: v.Clicks})
+	}
+
+	return top
+}
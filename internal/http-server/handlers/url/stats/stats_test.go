@@ -0,0 +1,142 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"urlShortener/internal/http-server/handlers/url/stats/mocks"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsHandler(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 1, 8, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name       string
+		query      string
+		mockSetup  func(m *mocks.ClickStatsGetter)
+		wantCode   int
+		wantError  string
+		wantTotal  int64
+		wantSeries int
+	}{
+		{
+			name:  "success",
+			query: "?from=2026-01-01T00:00:00Z&to=2026-01-08T00:00:00Z&bucket=hour&top=5",
+			mockSetup: func(m *mocks.ClickStatsGetter) {
+				q := storage.StatsQuery{From: from, To: to, Bucket: storage.BucketHour, Top: 5}
+				m.On("ClickStats", mock.Anything, "google", q).Return(storage.ClickStats{
+					Total:  3,
+					Unique: 2,
+					Series: []storage.BucketCount{
+						{Start: from, Clicks: 2},
+						{Start: from.Add(time.Hour), Clicks: 1},
+					},
+					TopReferrers:  []storage.ValueCount{{Value: "https://example.com", Clicks: 3}},
+					TopUserAgents: []storage.ValueCount{{Value: "curl", Clicks: 3}},
+				}, nil)
+			},
+			wantCode:   http.StatusOK,
+			wantTotal:  3,
+			wantSeries: 2,
+		},
+		{
+			name:  "defaults",
+			query: "",
+			mockSetup: func(m *mocks.ClickStatsGetter) {
+				m.On("ClickStats", mock.Anything, "google", mock.MatchedBy(func(q storage.StatsQuery) bool {
+					return q.Bucket == storage.BucketDay && q.Top == defaultTop && q.To.Sub(q.From) == defaultRange
+				})).Return(storage.ClickStats{}, nil)
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "invalid from",
+			query:     "?from=yesterday",
+			mockSetup: func(m *mocks.ClickStatsGetter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field from must be an RFC 3339 timestamp",
+		},
+		{
+			name:      "from after to",
+			query:     "?from=2026-01-08T00:00:00Z&to=2026-01-01T00:00:00Z",
+			mockSetup: func(m *mocks.ClickStatsGetter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field from must be before to",
+		},
+		{
+			name:      "invalid bucket",
+			query:     "?bucket=month",
+			mockSetup: func(m *mocks.ClickStatsGetter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field bucket must be one of hour, day, week",
+		},
+		{
+			name:      "invalid top",
+			query:     "?top=0",
+			mockSetup: func(m *mocks.ClickStatsGetter) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field top must be between 1 and 100",
+		},
+		{
+			name:  "url not found",
+			query: "",
+			mockSetup: func(m *mocks.ClickStatsGetter) {
+				m.On("ClickStats", mock.Anything, "google", mock.Anything).Return(storage.ClickStats{}, storage.ErrURLNotFound)
+			},
+			wantCode:  http.StatusNotFound,
+			wantError: "not found",
+		},
+		{
+			name:  "internal error",
+			query: "",
+			mockSetup: func(m *mocks.ClickStatsGetter) {
+				m.On("ClickStats", mock.Anything, "google", mock.Anything).Return(storage.ClickStats{}, errors.New("db error"))
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantError: "internal error",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockGetter := mocks.NewClickStatsGetter(t)
+			tc.mockSetup(mockGetter)
+
+			r := chi.NewRouter()
+			r.Get("/{alias}/stats", New(slogdiscard.NewDiscardLogger(), mockGetter))
+
+			req := httptest.NewRequest(http.MethodGet, "/google/stats"+tc.query, nil)
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantCode, rec.Code)
+
+			var response Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+			if tc.wantError != "" {
+				assert.Equal(t, "Error", response.Status)
+				assert.Equal(t, tc.wantError, response.Error)
+				return
+			}
+
+			assert.Equal(t, "OK", response.Status)
+			assert.Equal(t, "google", response.Alias)
+			assert.Equal(t, tc.wantTotal, response.TotalClicks)
+			assert.Len(t, response.Series, tc.wantSeries)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Request struct {
+	URL string `json:"url" validate:"required,url"`
+}
+
+type Response struct {
+	resp.Response
+	Alias string `json:"alias,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+type URLUpdater interface {
+	UpdateURL(ctx context.Context, alias string, newURL string, version int64) (int64, error)
+}
+
+// New changes the destination of an existing alias. If the request carries
+// an If-Match header, the update only succeeds while the link still has
+// that ETag; the new ETag is returned in the response.
+func New(log *slog.Logger, urlUpdater URLUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.update.New"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		alias := chi.URLParam(r, "alias")
+		if alias == "" {
+			log.Error("alias is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid request"))
+			return
+		}
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.ValidationError(validateErr))
+			return
+		}
+
+		version, ok := parseIfMatch(r.Header.Get("If-Match"))
+		if !ok {
+			log.Info("unsupported If-Match", slog.String("if_match", r.Header.Get("If-Match")))
+			render.Status(r, http.StatusPreconditionFailed)
+			render.JSON(w, r, resp.Error("url was modified"))
+			return
+		}
+
+		newVersion, err := urlUpdater.UpdateURL(r.Context(), alias, req.URL, version)
+		if errors.Is(err, storage.ErrURLNotFound) {
+			log.Info("url not found", "alias", alias)
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("not found"))
+			return
+		}
+
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			log.Info("url was modified", "alias", alias)
+			render.Status(r, http.StatusPreconditionFailed)
+			render.JSON(w, r, resp.Error("url was modified"))
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to update url", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to update url"))
+			return
+		}
+
+		log.Info("url updated", slog.String("alias", alias), slog.Int64("version", newVersion))
+
+		w.Header().Set("ETag", ETag(newVersion))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Alias:    alias,
+			URL:      req.URL,
+		})
+	}
+}
+
+// ETag formats a link version as a strong entity tag.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch returns the version an If-Match header requires, or 0 when
+// any version is acceptable. ok is false for tags we could never have issued.
+func parseIfMatch(header string) (version int64, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, true
+	}
+
+	tag := strings.TrimPrefix(header, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+
+	return version, true
+}
@@ -0,0 +1,162 @@
+package update
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"urlShortener/internal/http-server/handlers/url/update/mocks"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateHandler(t *testing.T) {
+	cases := []struct {
+		name      string
+		body      string
+		ifMatch   string
+		mockSetup func(m *mocks.URLUpdater)
+		wantCode  int
+		wantError string
+		wantETag  string
+	}{
+		{
+			name: "success",
+			body: `{"url": "https://google.com"}`,
+			mockSetup: func(m *mocks.URLUpdater) {
+				m.On("UpdateURL", mock.Anything, "google", "https://google.com", int64(0)).Return(int64(2), nil)
+			},
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+		},
+		{
+			name:    "success with If-Match",
+			body:    `{"url": "https://google.com"}`,
+			ifMatch: `"3"`,
+			mockSetup: func(m *mocks.URLUpdater) {
+				m.On("UpdateURL", mock.Anything, "google", "https://google.com", int64(3)).Return(int64(4), nil)
+			},
+			wantCode: http.StatusOK,
+			wantETag: `"4"`,
+		},
+		{
+			name:    "weak If-Match",
+			body:    `{"url": "https://google.com"}`,
+			ifMatch: `W/"3"`,
+			mockSetup: func(m *mocks.URLUpdater) {
+				m.On("UpdateURL", mock.Anything, "google", "https://google.com", int64(3)).Return(int64(4), nil)
+			},
+			wantCode: http.StatusOK,
+			wantETag: `"4"`,
+		},
+		{
+			name:    "If-Match any",
+			body:    `{"url": "https://google.com"}`,
+			ifMatch: `*`,
+			mockSetup: func(m *mocks.URLUpdater) {
+				m.On("UpdateURL", mock.Anything, "google", "https://google.com", int64(0)).Return(int64(2), nil)
+			},
+			wantCode: http.StatusOK,
+			wantETag: `"2"`,
+		},
+		{
+			name:    "version mismatch",
+			body:    `{"url": "https://google.com"}`,
+			ifMatch: `"1"`,
+			mockSetup: func(m *mocks.URLUpdater) {
+				m.On("UpdateURL", mock.Anything, "google", "https://google.com", int64(1)).Return(int64(0), storage.ErrVersionMismatch)
+			},
+			wantCode:  http.StatusPreconditionFailed,
+			wantError: "url was modified",
+		},
+		{
+			name:      "foreign If-Match",
+			body:      `{"url": "https://google.com"}`,
+			ifMatch:   `"abc"`,
+			mockSetup: func(m *mocks.URLUpdater) {},
+			wantCode:  http.StatusPreconditionFailed,
+			wantError: "url was modified",
+		},
+		{
+			name: "url not found",
+			body: `{"url": "https://google.com"}`,
+			mockSetup: func(m *mocks.URLUpdater) {
+				m.On("UpdateURL", mock.Anything, "google", "https://google.com", int64(0)).Return(int64(0), storage.ErrURLNotFound)
+			},
+			wantCode:  http.StatusNotFound,
+			wantError: "not found",
+		},
+		{
+			name: "update error",
+			body: `{"url": "https://google.com"}`,
+			mockSetup: func(m *mocks.URLUpdater) {
+				m.On("UpdateURL", mock.Anything, "google", "https://google.com", int64(0)).Return(int64(0), errors.New("db error"))
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantError: "failed to update url",
+		},
+		{
+			name:      "invalid json",
+			body:      `{"url": "https://google.com"`,
+			mockSetup: func(m *mocks.URLUpdater) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "failed to decode request",
+		},
+		{
+			name:      "empty url",
+			body:      `{"url": ""}`,
+			mockSetup: func(m *mocks.URLUpdater) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field URL is a required field",
+		},
+		{
+			name:      "invalid url format",
+			body:      `{"url": "not-a-url"}`,
+			mockSetup: func(m *mocks.URLUpdater) {},
+			wantCode:  http.StatusBadRequest,
+			wantError: "field URL is not a valid URL",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUpdater := mocks.NewURLUpdater(t)
+			tc.mockSetup(mockUpdater)
+
+			r := chi.NewRouter()
+			r.Patch("/{alias}", New(slogdiscard.NewDiscardLogger(), mockUpdater))
+
+			req := httptest.NewRequest(http.MethodPatch, "/google", bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantCode, rec.Code)
+
+			var response Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+			if tc.wantError != "" {
+				assert.Equal(t, "Error", response.Status)
+				assert.Equal(t, tc.wantError, response.Error)
+				return
+			}
+
+			assert.Equal(t, "OK", response.Status)
+			assert.Equal(t, "google", response.Alias)
+			assert.Equal(t, tc.wantETag, rec.Header().Get("ETag"))
+		})
+	}
+}
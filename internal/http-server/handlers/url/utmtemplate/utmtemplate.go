@@ -0,0 +1,194 @@
+package utmtemplate
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"urlShortener/internal/http-server/handlers/url/save"
+	resp "urlShortener/internal/lib/api/response"
+	"urlShortener/internal/lib/logger/sl"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+// namePattern is what template names may look like; they are path segments.
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type Template struct {
+	Name string   `json:"name"`
+	UTM  save.UTM `json:"utm"`
+}
+
+type Response struct {
+	resp.Response
+	Template
+}
+
+type ListResponse struct {
+	resp.Response
+	Templates []Template `json:"templates"`
+}
+
+type TemplateStore interface {
+	SaveUTMTemplate(ctx context.Context, t storage.UTMTemplate) error
+	GetUTMTemplate(ctx context.Context, name string) (storage.UTMTemplate, error)
+	ListUTMTemplates(ctx context.Context) ([]storage.UTMTemplate, error)
+	DeleteUTMTemplate(ctx context.Context, name string) error
+}
+
+// NewList returns all UTM templates ordered by name.
+func NewList(log *slog.Logger, store TemplateStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.utmtemplate.NewList"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		templates, err := store.ListUTMTemplates(r.Context())
+		if err != nil {
+			log.Error("failed to list utm templates", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		res := ListResponse{Response: resp.OK(), Templates: make([]Template, 0, len(templates))}
+		for _, t := range templates {
+			res.Templates = append(res.Templates, Template{Name: t.Name, UTM: save.NewUTM(t.UTM)})
+		}
+
+		render.JSON(w, r, res)
+	}
+}
+
+// NewGet returns the template named in the path.
+func NewGet(log *slog.Logger, store TemplateStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.utmtemplate.NewGet"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		name := chi.URLParam(r, "name")
+
+		t, err := store.GetUTMTemplate(r.Context(), name)
+		if errors.Is(err, storage.ErrUTMTemplateNotFound) {
+			log.Info("utm template not found", slog.String("name", name))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("not found"))
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to get utm template", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Template: Template{Name: t.Name, UTM: save.NewUTM(t.UTM)},
+		})
+	}
+}
+
+// NewPut creates the template named in the path from the UTM fields in the
+// body, or replaces it. Links using the template pick the change up on
+// their next redirect.
+func NewPut(log *slog.Logger, store TemplateStore, validate *validator.Validate) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.utmtemplate.NewPut"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		name := chi.URLParam(r, "name")
+		if !namePattern.MatchString(name) {
+			log.Error("invalid template name", slog.String("name", name))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("field name may only contain up to 64 of the characters a-zA-Z0-9_-"))
+			return
+		}
+
+		var req save.UTM
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.ValidationError(validateErr))
+			return
+		}
+
+		if req.Storage().Empty() {
+			log.Error("utm template is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("utm template must set at least one field"))
+			return
+		}
+
+		t := storage.UTMTemplate{Name: name, UTM: req.Storage()}
+
+		if err := store.SaveUTMTemplate(r.Context(), t); err != nil {
+			log.Error("failed to save utm template", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		log.Info("utm template saved", slog.String("name", name))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Template: Template{Name: name, UTM: req},
+		})
+	}
+}
+
+// NewDelete removes the template named in the path. Links that use it
+// keep their own UTM fields.
+func NewDelete(log *slog.Logger, store TemplateStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.utmtemplate.NewDelete"
+
+		log := log.With(slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		name := chi.URLParam(r, "name")
+
+		err := store.DeleteUTMTemplate(r.Context(), name)
+		if errors.Is(err, storage.ErrUTMTemplateNotFound) {
+			log.Info("utm template not found", slog.String("name", name))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("not found"))
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to delete utm template", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+			return
+		}
+
+		log.Info("utm template deleted", slog.String("name", name))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
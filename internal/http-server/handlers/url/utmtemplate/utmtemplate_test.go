@@ -0,0 +1,145 @@
+package utmtemplate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"urlShortener/internal/http-server/handlers/url/utmtemplate/mocks"
+	"urlShortener/internal/lib/slogdiscard"
+	"urlShortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouter(store *mocks.TemplateStore) chi.Router {
+	log := slogdiscard.NewDiscardLogger()
+
+	r := chi.NewRouter()
+	r.Get("/", NewList(log, store))
+	r.Get("/{name}", NewGet(log, store))
+	r.Put("/{name}", NewPut(log, store, validator.New()))
+	r.Delete("/{name}", NewDelete(log, store))
+
+	return r
+}
+
+func TestPutHandler(t *testing.T) {
+	cases := []struct {
+		name       string
+		path       string
+		body       string
+		mockSetup  func(m *mocks.TemplateStore)
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name: "success",
+			path: "/newsletter",
+			body: `{"source": "newsletter", "medium": "email"}`,
+			mockSetup: func(m *mocks.TemplateStore) {
+				m.On("SaveUTMTemplate", mock.Anything, storage.UTMTemplate{
+					Name: "newsletter",
+					UTM:  storage.UTM{Source: "newsletter", Medium: "email"},
+				}).Return(nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid name",
+			path:       "/no%20spaces",
+			body:       `{"source": "x"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "field name may only contain up to 64 of the characters a-zA-Z0-9_-",
+		},
+		{
+			name:       "empty template",
+			path:       "/empty",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "utm template must set at least one field",
+		},
+		{
+			name:       "field too long",
+			path:       "/long",
+			body:       `{"term": "` + strings.Repeat("x", 257) + `"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "field Term must be at most 256 characters long",
+		},
+		{
+			name: "storage error",
+			path: "/broken",
+			body: `{"source": "x"}`,
+			mockSetup: func(m *mocks.TemplateStore) {
+				m.On("SaveUTMTemplate", mock.Anything, mock.Anything).Return(errors.New("db error"))
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantError:  "internal error",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := mocks.NewTemplateStore(t)
+			if tc.mockSetup != nil {
+				tc.mockSetup(store)
+			}
+
+			req := httptest.NewRequest(http.MethodPut, tc.path, strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+
+			newRouter(store).ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantStatus, rec.Code)
+
+			var response Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			assert.Equal(t, tc.wantError, response.Error)
+		})
+	}
+}
+
+func TestGetListDeleteHandlers(t *testing.T) {
+	store := mocks.NewTemplateStore(t)
+	store.On("ListUTMTemplates", mock.Anything).
+		Return([]storage.UTMTemplate{{Name: "ads", UTM: storage.UTM{Source: "google", Medium: "cpc"}}}, nil)
+	store.On("GetUTMTemplate", mock.Anything, "ads").
+		Return(storage.UTMTemplate{Name: "ads", UTM: storage.UTM{Source: "google"}}, nil)
+	store.On("GetUTMTemplate", mock.Anything, "unknown").
+		Return(storage.UTMTemplate{}, storage.ErrUTMTemplateNotFound)
+	store.On("DeleteUTMTemplate", mock.Anything, "ads").Return(nil)
+	store.On("DeleteUTMTemplate", mock.Anything, "unknown").Return(storage.ErrUTMTemplateNotFound)
+
+	router := newRouter(store)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list ListResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Templates, 1)
+	assert.Equal(t, "ads", list.Templates[0].Name)
+	assert.Equal(t, "cpc", list.Templates[0].UTM.Medium)
+
+	rec = serve(http.MethodGet, "/ads")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "google", got.UTM.Source)
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/unknown").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/ads").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/unknown").Code)
+}
@@ -0,0 +1,96 @@
+package aliasrule
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Tag is the validation tag that applies Rules to a field.
+const Tag = "alias"
+
+// Rules restrict the aliases clients may choose.
+type Rules struct {
+	MinLength int
+	MaxLength int
+	// Charset is the body of a regular expression character class listing
+	// the allowed characters, e.g. "a-zA-Z0-9_-".
+	Charset string
+	// Reserved aliases cannot be used at all, in any letter case.
+	Reserved []string
+	// Denied words cannot appear anywhere in an alias, in any letter case.
+	Denied []string
+}
+
+// NewValidator returns a validator on which the "alias" tag enforces r.
+func NewValidator(r Rules) (*validator.Validate, error) {
+	charset, err := regexp.Compile("^[" + r.Charset + "]*$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid alias charset %q: %w", r.Charset, err)
+	}
+
+	v := validator.New()
+
+	if err := v.RegisterValidation("alias_charset", func(fl validator.FieldLevel) bool {
+		return charset.MatchString(fl.Field().String())
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := v.RegisterValidation("alias_reserved", func(fl validator.FieldLevel) bool {
+		return !r.reserved(fl.Field().String())
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := v.RegisterValidation("alias_denied", func(fl validator.FieldLevel) bool {
+		return !r.denied(fl.Field().String())
+	}); err != nil {
+		return nil, err
+	}
+
+	// The charset goes in as a parameter only so that error messages can
+	// name it; commas and pipes would otherwise split the tag.
+	param := strings.NewReplacer(",", "0x2C", "|", "0x7C").Replace(r.Charset)
+
+	var tags []string
+	if r.MinLength > 0 {
+		tags = append(tags, fmt.Sprintf("min=%d", r.MinLength))
+	}
+	if r.MaxLength > 0 {
+		tags = append(tags, fmt.Sprintf("max=%d", r.MaxLength))
+	}
+	tags = append(tags, "alias_charset="+param, "alias_reserved", "alias_denied")
+
+	v.RegisterAlias(Tag, strings.Join(tags, ","))
+
+	return v, nil
+}
+
+// Allows reports whether alias is neither reserved nor contains a denied
+// word. Generated aliases are checked this way, as they never break the
+// length and charset rules meant for clients.
+func (r Rules) Allows(alias string) bool {
+	return !r.reserved(alias) && !r.denied(alias)
+}
+
+func (r Rules) reserved(alias string) bool {
+	for _, word := range r.Reserved {
+		if strings.EqualFold(alias, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rules) denied(alias string) bool {
+	alias = strings.ToLower(alias)
+	for _, word := range r.Denied {
+		if word != "" && strings.Contains(alias, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
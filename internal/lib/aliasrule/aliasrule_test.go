@@ -0,0 +1,69 @@
+package aliasrule
+
+import (
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type request struct {
+	Alias string `validate:"omitempty,alias"`
+}
+
+func TestNewValidator(t *testing.T) {
+	v, err := NewValidator(Rules{
+		MinLength: 3,
+		MaxLength: 8,
+		Charset:   "a-z,|",
+		Reserved:  []string{"Admin"},
+		Denied:    []string{"heck"},
+	})
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"":          "",
+		"abc":       "",
+		"a,b|c":     "",
+		"ab":        "min",
+		"abcdefghi": "max",
+		"abc1":      "alias_charset",
+		"admin":     "alias_reserved",
+		"ohheck":    "alias_denied",
+	}
+
+	for alias, wantTag := range cases {
+		err := v.Struct(request{Alias: alias})
+		if wantTag == "" {
+			assert.NoError(t, err, alias)
+			continue
+		}
+
+		var errs validator.ValidationErrors
+		require.ErrorAs(t, err, &errs, alias)
+		assert.Equal(t, wantTag, errs[0].ActualTag(), alias)
+	}
+}
+
+func TestNewValidator_CharsetParam(t *testing.T) {
+	v, err := NewValidator(Rules{Charset: "a-z,|"})
+	require.NoError(t, err)
+
+	var errs validator.ValidationErrors
+	require.ErrorAs(t, v.Struct(request{Alias: "A"}), &errs)
+	assert.Equal(t, "a-z,|", errs[0].Param())
+}
+
+func TestNewValidator_InvalidCharset(t *testing.T) {
+	_, err := NewValidator(Rules{Charset: "z-a"})
+	assert.Error(t, err)
+}
+
+func TestRules_Allows(t *testing.T) {
+	r := Rules{MinLength: 5, Charset: "a-z", Reserved: []string{"url"}, Denied: []string{"heck"}}
+
+	assert.True(t, r.Allows("a1"))
+	assert.False(t, r.Allows("URL"))
+	assert.False(t, r.Allows("oHeck1"))
+}
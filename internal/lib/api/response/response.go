@@ -2,6 +2,7 @@ package response
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
@@ -35,10 +36,45 @@ func ValidationError(errs validator.ValidationErrors) Response {
 
 	for _, err := range errs {
 		switch err.ActualTag() {
-		case "required":
+		case "required", "required_if":
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is a required field", err.Field()))
 		case "url":
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not a valid URL", err.Field()))
+		case "gt":
+			if err.Param() == "" {
+				errMsgs = append(errMsgs, fmt.Sprintf("field %s must be in the future", err.Field()))
+			} else {
+				errMsgs = append(errMsgs, fmt.Sprintf("field %s must be greater than %s", err.Field(), err.Param()))
+			}
+		case "min":
+			if err.Kind() == reflect.Slice {
+				errMsgs = append(errMsgs, fmt.Sprintf("field %s must have at least %s entries", err.Field(), err.Param()))
+			} else {
+				errMsgs = append(errMsgs, fmt.Sprintf("field %s must be at least %s characters long", err.Field(), err.Param()))
+			}
+		case "max":
+			if err.Kind() == reflect.Slice {
+				errMsgs = append(errMsgs, fmt.Sprintf("field %s must have at most %s entries", err.Field(), err.Param()))
+			} else {
+				errMsgs = append(errMsgs, fmt.Sprintf("field %s must be at most %s characters long", err.Field(), err.Param()))
+			}
+		case "alias_charset":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s may only contain the characters %s", err.Field(), err.Param()))
+		case "alias_reserved":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s is a reserved word", err.Field()))
+		case "alias_denied":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s contains a word that is not allowed", err.Field()))
+		case "oneof":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s must be one of %s", err.Field(), err.Param()))
+		case "excluded_with":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s cannot be used together with %s", err.Field(), err.Param()))
+		case "iso3166_1_alpha2":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s must be an ISO 3166-1 alpha-2 country code", err.Field()))
+		case "excluded_unless":
+			field, value, _ := strings.Cut(err.Param(), " ")
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s can only be used when %s is %s", err.Field(), field, value))
+		case "excluded_without":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s can only be used together with %s", err.Field(), err.Param()))
 		default:
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not valid", err.Field()))
 		}
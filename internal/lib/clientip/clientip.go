@@ -0,0 +1,92 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// FromRequest returns the IP address the request came from, without the port.
+func FromRequest(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Resolver finds the client address of requests that may have passed
+// through trusted reverse proxies. A nil Resolver trusts no proxy.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver trusts X-Forwarded-For from the given addresses and networks,
+// e.g. "10.0.0.1" or "10.0.0.0/8".
+func NewResolver(trusted []string) (*Resolver, error) {
+	const op = "lib.clientip.NewResolver"
+
+	r := &Resolver{}
+	for _, s := range trusted {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%s: invalid proxy %q: %w", op, s, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+
+	return r, nil
+}
+
+// Addr returns the address of the client behind req. Starting from the
+// peer, it walks X-Forwarded-For from right to left for as long as the hops
+// are trusted proxies, so clients cannot forge their address by sending the
+// header themselves. It returns the zero Addr if the peer address is not
+// an IP, as with some test requests.
+func (r *Resolver) Addr(req *http.Request) netip.Addr {
+	addr, err := netip.ParseAddr(FromRequest(req))
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr = addr.Unmap()
+
+	if !r.isTrusted(addr) {
+		return addr
+	}
+
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		addr = hop.Unmap()
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+
+	return addr
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_Addr(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8", "192.0.2.1"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct", "198.51.100.7:4000", nil, "198.51.100.7"},
+		{"untrusted peer sends header", "198.51.100.7:4000", []string{"81.2.69.142"}, "198.51.100.7"},
+		{"trusted proxy", "192.0.2.1:4000", []string{"81.2.69.142"}, "81.2.69.142"},
+		{"proxy chain", "10.0.0.2:4000", []string{"81.2.69.142, 10.1.1.1"}, "81.2.69.142"},
+		{"forged hop left of client", "10.0.0.2:4000", []string{"1.2.3.4, 81.2.69.142"}, "81.2.69.142"},
+		{"several headers", "10.0.0.2:4000", []string{"1.2.3.4", "81.2.69.142"}, "81.2.69.142"},
+		{"only proxies", "10.0.0.2:4000", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"garbage hop", "10.0.0.2:4000", []string{"81.2.69.142, unknown"}, "10.0.0.2"},
+		{"mapped ipv4", "[::ffff:10.0.0.2]:4000", []string{"2001:218::1"}, "2001:218::1"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remote
+			for _, v := range tc.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+
+			assert.Equal(t, netip.MustParseAddr(tc.want), r.Addr(req))
+		})
+	}
+}
+
+func TestResolver_Nil(t *testing.T) {
+	var r *Resolver
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:4000"
+	req.Header.Set("X-Forwarded-For", "81.2.69.142")
+
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), r.Addr(req))
+}
+
+func TestNewResolver_Invalid(t *testing.T) {
+	_, err := NewResolver([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+// Package geoip looks addresses up in a local MaxMind DB (.mmdb) file, such
+// as GeoLite2-Country, so lookups never touch the network.
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type Reader struct {
+	db *maxminddb.Reader
+}
+
+// countryRecord holds the fields Country reads from a database record.
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Open opens the database at path.
+func Open(path string) (*Reader, error) {
+	const op = "lib.geoip.Open"
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Reader{db: db}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country addr is in,
+// falling back to the country it is registered in. It returns "" for
+// addresses the database does not place.
+func (r *Reader) Country(addr netip.Addr) (string, error) {
+	const op = "lib.geoip.Country"
+
+	var record countryRecord
+	if err := r.db.Lookup(addr.Unmap().AsSlice(), &record); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode, nil
+	}
+
+	return record.RegisteredCountry.ISOCode, nil
+}
+
+// Close releases the database.
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,53 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/country.mmdb places 81.2.69.0/24 in GB, 89.160.20.0/24 in SE,
+// 2001:218::/32 in JP and registers 203.0.113.0/24 to AU without placing
+// it. Other packages use it as a stand-in for a real country database.
+//
+//go:generate go run ./testdata/mkdb testdata/country.mmdb
+const fixturePath = "testdata/country.mmdb"
+
+func TestCountry(t *testing.T) {
+	r, err := Open(fixturePath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+
+	cases := []struct {
+		addr string
+		want string
+	}{
+		{"81.2.69.142", "GB"},
+		{"89.160.20.1", "SE"},
+		{"::ffff:89.160.20.1", "SE"},
+		{"203.0.113.7", "AU"},
+		{"2001:218:1::1", "JP"},
+		{"8.8.8.8", ""},
+		{"2001:db8::1", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.addr, func(t *testing.T) {
+			got, err := r.Country(netip.MustParseAddr(tc.addr))
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestOpen_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o644))
+
+	_, err := Open(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+// Command mkdb writes the MaxMind DB file used as a stand-in for a real
+// country database in tests: an IPv6 tree with 24-bit records holding IPv4
+// networks under ::/96, as MaxMind's own databases do.
+//
+// Usage:
+//
+//	go run ./testdata/mkdb testdata/country.mmdb
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+)
+
+// Data section field types.
+const (
+	typeString = 2
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+)
+
+// dataSeparatorSize is the gap of zero bytes between search tree and data.
+const dataSeparatorSize = 16
+
+// metadataMarker precedes the metadata map at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+type network struct {
+	prefix netip.Prefix
+	record map[string]any
+}
+
+func country(code string) map[string]any {
+	return map[string]any{"iso_code": code, "names": map[string]any{"en": code}}
+}
+
+var networks = []network{
+	{netip.MustParsePrefix("81.2.69.0/24"), map[string]any{"country": country("GB"), "registered_country": country("GB")}},
+	{netip.MustParsePrefix("89.160.20.0/24"), map[string]any{"country": country("SE"), "registered_country": country("SE")}},
+	{netip.MustParsePrefix("203.0.113.0/24"), map[string]any{"registered_country": country("AU")}},
+	{netip.MustParsePrefix("2001:218::/32"), map[string]any{"country": country("JP"), "registered_country": country("JP")}},
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: mkdb <file>")
+		os.Exit(2)
+	}
+
+	if err := os.WriteFile(os.Args[1], writeDatabase(networks), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type node struct {
+	children [2]*node
+	// data is the index of the record of a leaf, or -1.
+	data int
+}
+
+func writeDatabase(networks []network) []byte {
+	root := &node{data: -1}
+	var records []map[string]any
+
+	for _, n := range networks {
+		records = append(records, n.record)
+
+		addr := n.prefix.Addr()
+		bits := n.prefix.Bits()
+		if addr.Is4() {
+			var b [16]byte
+			a := addr.As4()
+			copy(b[12:], a[:])
+			addr = netip.AddrFrom16(b)
+			bits += 96
+		}
+
+		b := addr.As16()
+		cur := root
+		for i := range bits {
+			bit := b[i/8] >> (7 - i%8) & 1
+			if cur.children[bit] == nil {
+				cur.children[bit] = &node{data: -1}
+			}
+			cur = cur.children[bit]
+		}
+		cur.data = len(records) - 1
+	}
+
+	// Number the inner nodes breadth first, root first.
+	var nodes []*node
+	index := map[*node]int{}
+	queue := []*node{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			if child != nil && child.data < 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var data bytes.Buffer
+	offsets := make([]int, len(records))
+	for i, record := range records {
+		offsets[i] = data.Len()
+		encode(&data, record)
+	}
+
+	nodeCount := len(nodes)
+
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for _, child := range n.children {
+			var v int
+			switch {
+			case child == nil:
+				v = nodeCount
+			case child.data >= 0:
+				v = nodeCount + dataSeparatorSize + offsets[child.data]
+			default:
+				v = index[child]
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+
+	out.Write(make([]byte, dataSeparatorSize))
+	out.Write(data.Bytes())
+	out.Write(metadataMarker)
+	encode(&out, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               "Test-Country",
+		"description":                 map[string]any{"en": "Test country database"},
+		"ip_version":                  uint16(6),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})
+
+	return out.Bytes()
+}
+
+func encode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case []any:
+		writeControl(buf, typeArray, len(v))
+		for _, e := range v {
+			encode(buf, e)
+		}
+	case map[string]any:
+		writeControl(buf, typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	default:
+		panic(fmt.Sprintf("cannot encode %T", v))
+	}
+}
+
+func writeUint(buf *bytes.Buffer, typ int, v uint64) {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	writeControl(buf, typ, len(b))
+	buf.Write(b)
+}
+
+func writeControl(buf *bytes.Buffer, typ, size int) {
+	var extra []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		extra = []byte{byte(size - 29)}
+		size = 29
+	case size < 65821:
+		s := size - 285
+		extra = []byte{byte(s >> 8), byte(s)}
+		size = 30
+	default:
+		s := size - 65821
+		extra = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+		size = 31
+	}
+
+	// Types past map are extended: their type byte follows the control byte.
+	if typ > typeMap {
+		buf.WriteByte(byte(size))
+		buf.WriteByte(byte(typ - 7))
+	} else {
+		buf.WriteByte(byte(typ<<5 | size))
+	}
+	buf.Write(extra)
+}
@@ -0,0 +1,13 @@
+// Package metrics holds the process counters published at /debug/vars.
+package metrics
+
+import "expvar"
+
+var (
+	// AliasCollisions counts generated aliases that were already taken.
+	AliasCollisions = expvar.NewInt("alias_collisions")
+	// AliasGrowths counts generated aliases made longer after collisions.
+	AliasGrowths = expvar.NewInt("alias_growths")
+	// AliasExhausted counts links that found no free generated alias.
+	AliasExhausted = expvar.NewInt("alias_exhausted")
+)
@@ -0,0 +1,34 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxLength is the longest password bcrypt accepts, in bytes.
+const MaxLength = 72
+
+var ErrTooLong = errors.New("password too long")
+
+// Hash returns the bcrypt hash of password.
+func Hash(password string) (string, error) {
+	const op = "lib.password.Hash"
+
+	if len(password) > MaxLength {
+		return "", fmt.Errorf("%s: %w", op, ErrTooLong)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return string(hash), nil
+}
+
+// Check reports whether password matches hash.
+func Check(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
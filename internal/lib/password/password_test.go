@@ -0,0 +1,23 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *testing.T) {
+	hash, err := Hash("s3cret")
+	require.NoError(t, err)
+	assert.NotEqual(t, "s3cret", hash)
+
+	assert.True(t, Check(hash, "s3cret"))
+	assert.False(t, Check(hash, "S3cret"))
+	assert.False(t, Check(hash, ""))
+	assert.False(t, Check("", "s3cret"))
+
+	_, err = Hash(strings.Repeat("x", MaxLength+1))
+	assert.ErrorIs(t, err, ErrTooLong)
+}
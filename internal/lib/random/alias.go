@@ -0,0 +1,240 @@
+package random
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"urlShortener/internal/lib/metrics"
+)
+
+const base62 = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// AliasGenerator makes up aliases for links created without one.
+type AliasGenerator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// Lengthener is implemented by generators that can make longer, and so
+// less collision-prone, aliases.
+type Lengthener interface {
+	// GenerateLonger returns an alias extra characters or syllables longer
+	// than Generate would.
+	GenerateLonger(ctx context.Context, extra int) (string, error)
+}
+
+// AliasSource draws generated aliases for successive attempts at a free one.
+type AliasSource struct {
+	Generator AliasGenerator
+	// MaxAttempts bounds how many aliases are tried for one link.
+	MaxAttempts int
+	// GrowEvery makes aliases one step longer after every GrowEvery
+	// attempts, if Generator is a Lengthener. Zero never grows them.
+	GrowEvery int
+	// Allowed reports whether a generated alias may be handed out, e.g. that
+	// it is no reserved word. Rejected aliases are skipped. Nil allows all.
+	Allowed func(alias string) bool
+}
+
+// maxRejected bounds how many rejected aliases in a row Alias skips.
+const maxRejected = 100
+
+// ErrNoAllowedAlias is returned by AliasSource.Alias when the generator
+// keeps making aliases that Allowed rejects.
+var ErrNoAllowedAlias = errors.New("no allowed alias")
+
+// Alias returns an alias for the given attempt, counted from 0.
+func (s AliasSource) Alias(ctx context.Context, attempt int) (string, error) {
+	l, ok := s.Generator.(Lengthener)
+	grow := ok && s.GrowEvery > 0 && attempt >= s.GrowEvery
+	if grow && attempt%s.GrowEvery == 0 {
+		metrics.AliasGrowths.Add(1)
+	}
+
+	for range maxRejected {
+		var alias string
+		var err error
+		if grow {
+			alias, err = l.GenerateLonger(ctx, attempt/s.GrowEvery)
+		} else {
+			alias, err = s.Generator.Generate(ctx)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if s.Allowed == nil || s.Allowed(alias) {
+			return alias, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w in %d tries", ErrNoAllowedAlias, maxRejected)
+}
+
+// Counter returns a number that it never returns again, such as the next
+// value of a database sequence shared by all replicas.
+type Counter func(ctx context.Context) (int64, error)
+
+// Base62Generator returns crypto-random base62 strings of a fixed length.
+type Base62Generator struct {
+	length int
+}
+
+func NewBase62Generator(length int) *Base62Generator {
+	return &Base62Generator{length: length}
+}
+
+func (g *Base62Generator) Generate(context.Context) (string, error) {
+	return NewRandomString(g.length), nil
+}
+
+func (g *Base62Generator) GenerateLonger(_ context.Context, extra int) (string, error) {
+	return NewRandomString(g.length + extra), nil
+}
+
+// SequentialGenerator returns the numbers drawn from a Counter in base62.
+type SequentialGenerator struct {
+	next Counter
+}
+
+func NewSequentialGenerator(next Counter) *SequentialGenerator {
+	return &SequentialGenerator{next: next}
+}
+
+func (g *SequentialGenerator) Generate(ctx context.Context) (string, error) {
+	n, err := g.next(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return encode(n, base62), nil
+}
+
+// HashidsGenerator numbers aliases like SequentialGenerator, but scrambles
+// each number with a salted alphabet in the manner of hashids, so that
+// neighbouring aliases look unrelated. Different numbers never share an alias.
+type HashidsGenerator struct {
+	next      Counter
+	salt      string
+	alphabet  string
+	pad       byte
+	minLength int
+}
+
+func NewHashidsGenerator(next Counter, salt string, minLength int) *HashidsGenerator {
+	alphabet := shuffle(base62, salt)
+
+	return &HashidsGenerator{
+		next: next,
+		salt: salt,
+		// The last character never appears in an encoded number, which
+		// keeps padded aliases distinct from unpadded ones.
+		alphabet:  alphabet[:len(alphabet)-1],
+		pad:       alphabet[len(alphabet)-1],
+		minLength: minLength,
+	}
+}
+
+func (g *HashidsGenerator) Generate(ctx context.Context) (string, error) {
+	return g.GenerateLonger(ctx, 0)
+}
+
+// GenerateLonger raises the minimum length by extra.
+func (g *HashidsGenerator) GenerateLonger(ctx context.Context, extra int) (string, error) {
+	n, err := g.next(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// The lottery character picks one of many alphabets for the rest.
+	lottery := g.alphabet[n%int64(len(g.alphabet))]
+	digits := encode(n, shuffle(g.alphabet, string(lottery)+g.salt))
+
+	var b strings.Builder
+	b.WriteByte(lottery)
+	for i := 1 + len(digits); i < g.minLength+extra; i++ {
+		b.WriteByte(g.pad)
+	}
+	b.WriteString(digits)
+
+	return b.String(), nil
+}
+
+// WordsGenerator returns pronounceable made-up words of consonant-vowel
+// syllables joined by dashes, such as "bakoti-muresa".
+type WordsGenerator struct {
+	words     int
+	syllables int
+}
+
+const (
+	consonants = "bdfghjklmnprstvz"
+	vowels     = "aeiou"
+)
+
+func NewWordsGenerator(words, syllables int) *WordsGenerator {
+	return &WordsGenerator{words: words, syllables: syllables}
+}
+
+func (g *WordsGenerator) Generate(ctx context.Context) (string, error) {
+	return g.GenerateLonger(ctx, 0)
+}
+
+// GenerateLonger adds extra syllables to every word.
+func (g *WordsGenerator) GenerateLonger(_ context.Context, extra int) (string, error) {
+	syllables := g.syllables + extra
+
+	words := make([]string, g.words)
+	for i := range words {
+		b := make([]byte, 0, 2*syllables)
+		for j := 0; j < syllables; j++ {
+			b = append(b, consonants[randIntn(len(consonants))], vowels[randIntn(len(vowels))])
+		}
+		words[i] = string(b)
+	}
+
+	return strings.Join(words, "-"), nil
+}
+
+func randIntn(n int) int {
+	v, _ := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	return int(v.Int64())
+}
+
+// encode writes n in the positional system whose digits are alphabet.
+func encode(n int64, alphabet string) string {
+	base := int64(len(alphabet))
+	if n == 0 {
+		return alphabet[:1]
+	}
+
+	var b []byte
+	for ; n > 0; n /= base {
+		b = append(b, alphabet[n%base])
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
+
+// shuffle permutes alphabet deterministically by salt, as hashids does.
+func shuffle(alphabet, salt string) string {
+	if salt == "" {
+		return alphabet
+	}
+
+	b := []byte(alphabet)
+	for i, v, p := len(b)-1, 0, 0; i > 0; i, v = i-1, v+1 {
+		v %= len(salt)
+		n := int(salt[v])
+		p += n
+		j := (n + v + p) % i
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
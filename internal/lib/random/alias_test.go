@@ -0,0 +1,131 @@
+package random
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync/atomic"
+	"testing"
+)
+
+// counter counts up from start.
+func counter(start int64) Counter {
+	var next atomic.Int64
+	next.Store(start)
+
+	return func(context.Context) (int64, error) {
+		return next.Add(1) - 1, nil
+	}
+}
+
+func TestBase62Generator(t *testing.T) {
+	g := NewBase62Generator(8)
+
+	alias, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if len(alias) != 8 {
+		t.Errorf("Generate() returned %q, want 8 characters", alias)
+	}
+}
+
+func TestSequentialGenerator(t *testing.T) {
+	g := NewSequentialGenerator(counter(61))
+
+	want := []string{"Z", "10", "11"}
+	for _, w := range want {
+		if got, _ := g.Generate(context.Background()); got != w {
+			t.Errorf("Generate() = %q, want %q", got, w)
+		}
+	}
+
+	// Counter failures, e.g. an unreachable database, are passed on.
+	failing := NewSequentialGenerator(func(context.Context) (int64, error) {
+		return 0, errors.New("connection refused")
+	})
+	if _, err := failing.Generate(context.Background()); err == nil {
+		t.Error("Generate() ignored the counter error")
+	}
+}
+
+func TestHashidsGenerator(t *testing.T) {
+	ctx := context.Background()
+
+	g := NewHashidsGenerator(counter(0), "pepper", 5)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		alias, _ := g.Generate(ctx)
+		if len(alias) < 5 {
+			t.Fatalf("Generate() returned %q, want at least 5 characters", alias)
+		}
+		if seen[alias] {
+			t.Fatalf("Generate() returned %q twice", alias)
+		}
+		seen[alias] = true
+	}
+
+	generate := func(start int64, salt string) string {
+		alias, _ := NewHashidsGenerator(counter(start), salt, 5).Generate(ctx)
+		return alias
+	}
+
+	// The same salt and counter give the same aliases.
+	if generate(42, "pepper") != generate(42, "pepper") {
+		t.Error("Generate() is not deterministic for equal salt and start")
+	}
+
+	// A different salt gives different aliases.
+	if generate(42, "pepper") == generate(42, "salt") {
+		t.Error("Generate() ignores the salt")
+	}
+}
+
+func TestWordsGenerator(t *testing.T) {
+	g := NewWordsGenerator(2, 3)
+
+	alias, _ := g.Generate(context.Background())
+	if !regexp.MustCompile(`^([bdfghjklmnprstvz][aeiou]){3}-([bdfghjklmnprstvz][aeiou]){3}$`).MatchString(alias) {
+		t.Errorf("Generate() returned %q, want two words of three syllables", alias)
+	}
+}
+
+func TestAliasSource_Grows(t *testing.T) {
+	ctx := context.Background()
+
+	s := AliasSource{Generator: NewBase62Generator(4), MaxAttempts: 10, GrowEvery: 2}
+
+	want := []int{4, 4, 5, 5, 6}
+	for attempt, w := range want {
+		if got, _ := s.Alias(ctx, attempt); len(got) != w {
+			t.Errorf("Alias(%d) has length %d, want %d", attempt, len(got), w)
+		}
+	}
+
+	// Generators that cannot grow keep making aliases as usual.
+	seq := AliasSource{Generator: NewSequentialGenerator(counter(1)), MaxAttempts: 10, GrowEvery: 1}
+	if got, _ := seq.Alias(ctx, 5); got != "1" {
+		t.Errorf("Alias(5) = %q, want %q", got, "1")
+	}
+}
+
+func TestAliasSource_Allowed(t *testing.T) {
+	ctx := context.Background()
+
+	s := AliasSource{
+		Generator:   NewSequentialGenerator(counter(1)),
+		MaxAttempts: 10,
+		Allowed:     func(alias string) bool { return alias != "1" && alias != "2" },
+	}
+
+	if got, _ := s.Alias(ctx, 0); got != "3" {
+		t.Errorf("Alias(0) = %q, want %q", got, "3")
+	}
+
+	// A generator that never makes an allowed alias gives up.
+	s.Allowed = func(string) bool { return false }
+	if _, err := s.Alias(ctx, 0); !errors.Is(err, ErrNoAllowedAlias) {
+		t.Errorf("Alias(0) error = %v, want ErrNoAllowedAlias", err)
+	}
+}
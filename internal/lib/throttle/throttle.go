@@ -0,0 +1,107 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter locks a key out for a window once it has failed max times within
+// that window. The window starts at the first failure.
+type Limiter struct {
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// pruneAt is the number of entries at which Fail next drops the
+	// expired ones.
+	pruneAt int
+}
+
+// minPruneAt is the fewest entries that make Fail prune.
+const minPruneAt = 1024
+
+type entry struct {
+	failures int
+	until    time.Time
+}
+
+// New returns a Limiter allowing max failures per key per window. A max of
+// zero or less disables it.
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:     max,
+		window:  window,
+		now:     time.Now,
+		entries: make(map[string]*entry),
+		pruneAt: minPruneAt,
+	}
+}
+
+// Allow reports whether key may try again, and if not, how long until it may.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.max <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	e, ok := l.entries[key]
+	if !ok || !now.Before(e.until) {
+		return true, 0
+	}
+
+	if e.failures < l.max {
+		return true, 0
+	}
+
+	return false, e.until.Sub(now)
+}
+
+// Fail records a failed attempt for key.
+func (l *Limiter) Fail(key string) {
+	if l.max <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.entries) >= l.pruneAt {
+		l.prune(now)
+	}
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{until: now.Add(l.window)}
+		l.entries[key] = e
+	}
+
+	e.failures++
+}
+
+// Reset forgets the failures recorded for key.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+// prune drops expired entries so keys that stop failing don't pile up. The
+// next prune waits until the entries left have doubled, so that scanning
+// them stays cheap per failure.
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		if !now.Before(e.until) {
+			delete(l.entries, key)
+		}
+	}
+
+	l.pruneAt = max(minPruneAt, 2*len(l.entries))
+}
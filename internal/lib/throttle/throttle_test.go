@@ -0,0 +1,75 @@
+package throttle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for range 3 {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+		l.Fail("a")
+	}
+
+	ok, retry := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, retry)
+
+	ok, _ = l.Allow("b")
+	assert.True(t, ok, "keys are independent")
+
+	now = now.Add(30 * time.Second)
+	ok, retry = l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, retry)
+
+	now = now.Add(30 * time.Second)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok, "window has passed")
+
+	l.Fail("a")
+	l.Fail("a")
+	l.Fail("a")
+	l.Reset("a")
+	ok, _ = l.Allow("a")
+	assert.True(t, ok, "reset clears failures")
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	l := New(0, time.Minute)
+
+	for range 10 {
+		l.Fail("a")
+	}
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := range minPruneAt - 1 {
+		l.Fail(fmt.Sprintf("old%d", i))
+	}
+
+	now = now.Add(time.Minute)
+	l.Fail("new")
+	assert.Len(t, l.entries, minPruneAt, "pruned before the bound")
+
+	// Reaching the bound drops the expired entries.
+	l.Fail("newer")
+	assert.Len(t, l.entries, 2)
+}
@@ -0,0 +1,28 @@
+package useragent
+
+import "strings"
+
+// Platforms Platform tells apart.
+const (
+	IOS     = "ios"
+	Android = "android"
+	Desktop = "desktop"
+)
+
+// Platform guesses the platform a User-Agent header comes from. It returns
+// "" for agents it can't place, such as bots and other phones.
+func Platform(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return IOS
+	case strings.Contains(ua, "Android"):
+		return Android
+	case strings.Contains(ua, "Mobile"), strings.Contains(ua, "bot"), strings.Contains(ua, "Bot"):
+		return ""
+	case strings.Contains(ua, "Windows NT"), strings.Contains(ua, "Macintosh"),
+		strings.Contains(ua, "X11"), strings.Contains(ua, "CrOS"):
+		return Desktop
+	default:
+		return ""
+	}
+}
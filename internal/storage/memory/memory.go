@@ -0,0 +1,722 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"urlShortener/internal/storage"
+)
+
+type Storage struct {
+	mu           sync.RWMutex
+	urls         map[string]entry
+	clicks       []click
+	utmTemplates map[string]string
+	lastID       int64
+	aliasNumber  int64
+	snapshotPath string
+	opts         storage.Options
+}
+
+type entry struct {
+	ID        int64      `json:"id"`
+	Alias     string     `json:"alias"`
+	URL       string     `json:"url"`
+	Version   int64      `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	// RedirectStatus is zero for the server default.
+	RedirectStatus int        `json:"redirect_status,omitempty"`
+	Passthrough    bool       `json:"passthrough,omitempty"`
+	UTM            string     `json:"utm,omitempty"`
+	UTMTemplate    string     `json:"utm_template,omitempty"`
+	PasswordHash   string     `json:"password_hash,omitempty"`
+	MaxClicks      int64      `json:"max_clicks,omitempty"`
+	ClicksLeft     int64      `json:"clicks_left,omitempty"`
+	NotBefore      *time.Time `json:"not_before,omitempty"`
+	FallbackURL    string     `json:"fallback_url,omitempty"`
+	Targets        string     `json:"targets,omitempty"`
+	CountryTargets string     `json:"country_targets,omitempty"`
+}
+
+func (e entry) toURL() storage.URL {
+	return storage.URL{
+		ID:         e.ID,
+		Alias:      e.Alias,
+		URL:        e.URL,
+		CreatedAt:  e.CreatedAt,
+		UpdatedAt:  e.UpdatedAt,
+		Version:    e.Version,
+		ClicksLeft: e.ClicksLeft,
+		URLOptions: storage.URLOptions{
+			ExpiresAt:      e.ExpiresAt,
+			CreatedBy:      e.CreatedBy,
+			RedirectStatus: e.RedirectStatus,
+			Passthrough:    e.Passthrough,
+			UTM:            storage.ParseUTM(e.UTM),
+			UTMTemplate:    e.UTMTemplate,
+			PasswordHash:   e.PasswordHash,
+			MaxClicks:      e.MaxClicks,
+			NotBefore:      e.NotBefore,
+			FallbackURL:    e.FallbackURL,
+			Targets:        storage.ParseTargets(e.Targets),
+			CountryTargets: storage.ParseCountryTargets(e.CountryTargets),
+		},
+	}
+}
+
+func (e entry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !e.ExpiresAt.After(now)
+}
+
+// click mirrors storage.Click with JSON tags for snapshots.
+type click struct {
+	Alias     string    `json:"alias"`
+	ClickedAt time.Time `json:"clicked_at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+}
+
+type snapshot struct {
+	LastID       int64             `json:"last_id"`
+	AliasNumber  int64             `json:"alias_number,omitempty"`
+	URLs         []entry           `json:"urls"`
+	Clicks       []click           `json:"clicks,omitempty"`
+	UTMTemplates map[string]string `json:"utm_templates,omitempty"`
+}
+
+// New creates an in-memory storage. If snapshotPath is not empty, the storage
+// is restored from it (when the file exists) and written back to it on Close.
+func New(snapshotPath string, opts storage.Options) (*Storage, error) {
+	const op = "storage.memory.New"
+
+	s := &Storage{
+		urls:         make(map[string]entry),
+		utmTemplates: make(map[string]string),
+		snapshotPath: snapshotPath,
+		opts:         opts,
+	}
+
+	if snapshotPath == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("%s: decode snapshot: %w", op, err)
+	}
+
+	s.lastID = snap.LastID
+	s.aliasNumber = snap.AliasNumber
+	s.clicks = snap.Clicks
+	if snap.UTMTemplates != nil {
+		s.utmTemplates = snap.UTMTemplates
+	}
+
+	var conflicts []string
+	for _, e := range snap.URLs {
+		key := opts.Alias(e.Alias)
+		if _, ok := s.urls[key]; ok {
+			conflicts = append(conflicts, key)
+		}
+		s.urls[key] = e
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("%s: %w: %s", op, storage.ErrAliasConflict, strings.Join(conflicts, ", "))
+	}
+
+	return s, nil
+}
+
+func (s *Storage) SaveURL(_ context.Context, urlToSave string, alias string, opts storage.URLOptions) (int64, error) {
+	key := s.opts.Alias(alias)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[key]; ok {
+		return 0, storage.ErrURLExists
+	}
+
+	s.lastID++
+	s.urls[key] = entry{
+		ID:             s.lastID,
+		Alias:          alias,
+		URL:            urlToSave,
+		Version:        1,
+		CreatedAt:      time.Now().UTC().Truncate(time.Second),
+		ExpiresAt:      opts.ExpiresAt,
+		CreatedBy:      opts.CreatedBy,
+		RedirectStatus: opts.RedirectStatus,
+		Passthrough:    opts.Passthrough,
+		UTM:            opts.UTM.Encode(),
+		UTMTemplate:    opts.UTMTemplate,
+		PasswordHash:   opts.PasswordHash,
+		MaxClicks:      opts.MaxClicks,
+		ClicksLeft:     opts.MaxClicks,
+		NotBefore:      opts.NotBefore,
+		FallbackURL:    opts.FallbackURL,
+		Targets:        storage.EncodeTargets(opts.Targets),
+		CountryTargets: storage.EncodeCountryTargets(opts.CountryTargets),
+	}
+
+	return s.lastID, nil
+}
+
+// SaveURLs inserts a batch of links. With atomic set, nothing is saved
+// unless every alias is free, and the first taken one is reported as a
+// *storage.BatchError.
+func (s *Storage) SaveURLs(_ context.Context, urls []storage.NewURL, atomic bool) ([]storage.SaveResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if atomic {
+		seen := make(map[string]bool, len(urls))
+		for i, u := range urls {
+			alias := s.opts.Alias(u.Alias)
+			if _, ok := s.urls[alias]; ok || seen[alias] {
+				return nil, &storage.BatchError{Index: i, Err: storage.ErrURLExists}
+			}
+			seen[alias] = true
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	results := make([]storage.SaveResult, len(urls))
+	for i, u := range urls {
+		key := s.opts.Alias(u.Alias)
+		if _, ok := s.urls[key]; ok {
+			results[i].Err = storage.ErrURLExists
+			continue
+		}
+
+		s.lastID++
+		s.urls[key] = entry{
+			ID:             s.lastID,
+			Alias:          u.Alias,
+			URL:            u.URL,
+			Version:        1,
+			CreatedAt:      now,
+			ExpiresAt:      u.ExpiresAt,
+			CreatedBy:      u.CreatedBy,
+			RedirectStatus: u.RedirectStatus,
+			Passthrough:    u.Passthrough,
+			UTM:            u.UTM.Encode(),
+			UTMTemplate:    u.UTMTemplate,
+			PasswordHash:   u.PasswordHash,
+			MaxClicks:      u.MaxClicks,
+			ClicksLeft:     u.MaxClicks,
+			NotBefore:      u.NotBefore,
+			FallbackURL:    u.FallbackURL,
+			Targets:        storage.EncodeTargets(u.Targets),
+			CountryTargets: storage.EncodeCountryTargets(u.CountryTargets),
+		}
+		results[i].ID = s.lastID
+	}
+
+	return results, nil
+}
+
+// GetURL returns the link stored under alias, or storage.ErrURLExpired
+// once its expiry time has passed.
+func (s *Storage) GetURL(_ context.Context, alias string) (storage.URL, error) {
+	alias = s.opts.Alias(alias)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.urls[alias]
+	if !ok {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
+
+	if e.expired(time.Now()) {
+		return storage.URL{}, storage.ErrURLExpired
+	}
+
+	u := e.toURL()
+	if u.Exhausted() {
+		return storage.URL{}, storage.ErrURLExhausted
+	}
+
+	return u, nil
+}
+
+// GetURLInfo returns the stored record for alias, expired or not.
+func (s *Storage) GetURLInfo(_ context.Context, alias string) (storage.URL, error) {
+	alias = s.opts.Alias(alias)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.urls[alias]
+	if !ok {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
+
+	return e.toURL(), nil
+}
+
+// GetURLByDestination returns the oldest link to urlToSave, compared in
+// normalized form, that is active at once, never expires and has no
+// password, click limit, targets, redirect status of its own, passthrough
+// or UTM parameters.
+func (s *Storage) GetURLByDestination(_ context.Context, urlToSave string) (storage.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	normalized := storage.NormalizeURL(urlToSave)
+
+	var found *entry
+	for _, e := range s.urls {
+		if e.ExpiresAt != nil || e.PasswordHash != "" || e.MaxClicks > 0 || e.NotBefore != nil || e.Targets != "" ||
+			e.CountryTargets != "" || e.RedirectStatus != 0 || e.Passthrough ||
+			e.UTM != "" || e.UTMTemplate != "" || storage.NormalizeURL(e.URL) != normalized {
+			continue
+		}
+		if found == nil || e.ID < found.ID {
+			found = &e
+		}
+	}
+
+	if found == nil {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
+
+	return found.toURL(), nil
+}
+
+func (s *Storage) DeleteURL(_ context.Context, alias string) error {
+	alias = s.opts.Alias(alias)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.urls[alias]
+	if !ok {
+		return storage.ErrURLNotFound
+	}
+
+	delete(s.urls, alias)
+	s.dropClicks(map[string]bool{e.Alias: true})
+
+	return nil
+}
+
+// DeleteURLs removes every link matching q, and their clicks.
+func (s *Storage) DeleteURLs(_ context.Context, q storage.DeleteQuery) (storage.DeleteResult, error) {
+	if q.Empty() {
+		return storage.DeleteResult{}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var wanted map[string]bool
+	if len(q.Aliases) > 0 {
+		wanted = make(map[string]bool, len(q.Aliases))
+		for _, alias := range q.Aliases {
+			wanted[s.opts.Alias(alias)] = true
+		}
+	}
+	domain := strings.ToLower(q.Domain)
+
+	var deleted []string
+	gone := make(map[string]bool)
+	for alias, e := range s.urls {
+		if wanted != nil && !wanted[alias] {
+			continue
+		}
+		if q.CreatedBefore != nil && !e.CreatedAt.Before(*q.CreatedBefore) {
+			continue
+		}
+		if domain != "" && storage.DomainOf(e.URL) != domain {
+			continue
+		}
+
+		delete(s.urls, alias)
+		deleted = append(deleted, alias)
+		gone[e.Alias] = true
+	}
+	s.dropClicks(gone)
+
+	return storage.DeleteResult{
+		Deleted:  int64(len(deleted)),
+		NotFound: q.Missing(deleted, s.opts.Alias),
+	}, nil
+}
+
+// ConsumeClick takes one of the visits left to a link with MaxClicks set.
+// It returns storage.ErrURLExhausted when none are left, which is always
+// the case for links without a limit.
+func (s *Storage) ConsumeClick(_ context.Context, alias string) error {
+	alias = s.opts.Alias(alias)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.urls[alias]
+	if !ok {
+		return storage.ErrURLNotFound
+	}
+
+	if e.ClicksLeft <= 0 {
+		return storage.ErrURLExhausted
+	}
+
+	e.ClicksLeft--
+	s.urls[alias] = e
+
+	return nil
+}
+
+// UpdateURL points alias at newURL and returns the link's new version.
+// If version is not zero, the update only happens while the link is still
+// at that version; otherwise storage.ErrVersionMismatch is returned.
+func (s *Storage) UpdateURL(_ context.Context, alias string, newURL string, version int64) (int64, error) {
+	alias = s.opts.Alias(alias)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.urls[alias]
+	if !ok {
+		return 0, storage.ErrURLNotFound
+	}
+
+	if version != 0 && e.Version != version {
+		return 0, storage.ErrVersionMismatch
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	e.URL = newURL
+	e.UpdatedAt = &now
+	e.Version++
+	s.urls[alias] = e
+
+	return e.Version, nil
+}
+
+// ListURLs returns one page of links matching q.
+func (s *Storage) ListURLs(_ context.Context, q storage.ListQuery) ([]storage.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	less := func(a, b entry) bool {
+		if q.SortBy == storage.SortByAlias {
+			return a.Alias < b.Alias
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	}
+
+	q.AliasPrefix = s.opts.Alias(q.AliasPrefix)
+
+	var after *entry
+	if q.After != nil {
+		after = &entry{Alias: q.After.Alias, CreatedAt: q.After.CreatedAt, ID: q.After.ID}
+	}
+
+	var matched []entry
+	for _, e := range s.urls {
+		if q.AliasPrefix != "" && !strings.HasPrefix(s.opts.Alias(e.Alias), q.AliasPrefix) {
+			continue
+		}
+		if q.URLContains != "" && !strings.Contains(strings.ToLower(e.URL), strings.ToLower(q.URLContains)) {
+			continue
+		}
+		if q.Domain != "" && storage.DomainOf(e.URL) != strings.ToLower(q.Domain) {
+			continue
+		}
+		if after != nil && !q.Desc && !less(*after, e) {
+			continue
+		}
+		if after != nil && q.Desc && !less(e, *after) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if q.Desc {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+
+	if len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+
+	urls := make([]storage.URL, 0, len(matched))
+	for _, e := range matched {
+		urls = append(urls, e.toURL())
+	}
+
+	return urls, nil
+}
+
+// NextAliasNumber returns a number for a generated alias. Numbers are never
+// handed out twice.
+func (s *Storage) NextAliasNumber(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.aliasNumber++
+
+	return s.aliasNumber, nil
+}
+
+// SaveUTMTemplate creates the template t or replaces the one of that name.
+func (s *Storage) SaveUTMTemplate(_ context.Context, t storage.UTMTemplate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.utmTemplates[t.Name] = t.UTM.Encode()
+
+	return nil
+}
+
+func (s *Storage) GetUTMTemplate(_ context.Context, name string) (storage.UTMTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	utm, ok := s.utmTemplates[name]
+	if !ok {
+		return storage.UTMTemplate{}, storage.ErrUTMTemplateNotFound
+	}
+
+	return storage.UTMTemplate{Name: name, UTM: storage.ParseUTM(utm)}, nil
+}
+
+// ListUTMTemplates returns all templates ordered by name.
+func (s *Storage) ListUTMTemplates(_ context.Context) ([]storage.UTMTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var templates []storage.UTMTemplate
+	for name, utm := range s.utmTemplates {
+		templates = append(templates, storage.UTMTemplate{Name: name, UTM: storage.ParseUTM(utm)})
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	return templates, nil
+}
+
+// DeleteUTMTemplate removes the named template. Links using it keep their
+// own UTM parameters.
+func (s *Storage) DeleteUTMTemplate(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.utmTemplates[name]; !ok {
+		return storage.ErrUTMTemplateNotFound
+	}
+
+	delete(s.utmTemplates, name)
+
+	return nil
+}
+
+// DeleteExpiredURLs removes all links that expired at or before before,
+// and their clicks.
+func (s *Storage) DeleteExpiredURLs(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gone := make(map[string]bool)
+	for alias, e := range s.urls {
+		if e.expired(before) {
+			delete(s.urls, alias)
+			gone[e.Alias] = true
+		}
+	}
+	s.dropClicks(gone)
+
+	return int64(len(gone)), nil
+}
+
+// dropClicks removes the clicks recorded on the given stored aliases. The
+// caller must hold the write lock.
+func (s *Storage) dropClicks(aliases map[string]bool) {
+	if len(aliases) == 0 {
+		return
+	}
+
+	kept := s.clicks[:0]
+	for _, c := range s.clicks {
+		if !aliases[c.Alias] {
+			kept = append(kept, c)
+		}
+	}
+	s.clicks = kept
+}
+
+// SaveClicks stores a batch of click events. Clicks on links deleted
+// meanwhile are dropped, so that a new link under the same alias starts
+// without them.
+func (s *Storage) SaveClicks(_ context.Context, clicks []storage.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range clicks {
+		e, ok := s.urls[s.opts.Alias(c.Alias)]
+		if !ok {
+			continue
+		}
+		// Clicks are recorded under the alias as stored.
+		c.Alias = e.Alias
+		s.clicks = append(s.clicks, click(c))
+	}
+
+	return nil
+}
+
+// ClickStats aggregates the clicks on alias selected by q.
+func (s *Storage) ClickStats(_ context.Context, alias string, q storage.StatsQuery) (storage.ClickStats, error) {
+	alias = s.opts.Alias(alias)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats storage.ClickStats
+
+	e, ok := s.urls[alias]
+	if !ok {
+		return stats, storage.ErrURLNotFound
+	}
+	alias = e.Alias
+
+	unique := make(map[string]struct{})
+	series := make(map[time.Time]int64)
+	referrers := make(map[string]int64)
+	userAgents := make(map[string]int64)
+
+	for _, c := range s.clicks {
+		if c.Alias != alias || c.ClickedAt.Before(q.From) || !c.ClickedAt.Before(q.To) {
+			continue
+		}
+
+		stats.Total++
+		if c.IPHash != "" {
+			unique[c.IPHash] = struct{}{}
+		}
+		series[q.Bucket.Truncate(c.ClickedAt)]++
+		if c.Referrer != "" {
+			referrers[c.Referrer]++
+		}
+		if c.UserAgent != "" {
+			userAgents[c.UserAgent]++
+		}
+	}
+
+	stats.Unique = int64(len(unique))
+
+	for start, clicks := range series {
+		stats.Series = append(stats.Series, storage.BucketCount{Start: start, Clicks: clicks})
+	}
+	sort.Slice(stats.Series, func(i, j int) bool {
+		return stats.Series[i].Start.Before(stats.Series[j].Start)
+	})
+
+	stats.TopReferrers = topValues(referrers, q.Top)
+	stats.TopUserAgents = topValues(userAgents, q.Top)
+
+	return stats, nil
+}
+
+// topValues returns the limit most frequent values, ties broken alphabetically.
+func topValues(counts map[string]int64, limit int) []storage.ValueCount {
+	var values []storage.ValueCount
+	for v, c := range counts {
+		values = append(values, storage.ValueCount{Value: v, Clicks: c})
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Clicks != values[j].Clicks {
+			return values[i].Clicks > values[j].Clicks
+		}
+		return values[i].Value < values[j].Value
+	})
+
+	if len(values) > limit {
+		values = values[:limit]
+	}
+
+	return values
+}
+
+// Close writes a snapshot to disk if a snapshot path was configured.
+func (s *Storage) Close() error {
+	const op = "storage.memory.Close"
+
+	if s.snapshotPath == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	snap := snapshot{
+		LastID:       s.lastID,
+		AliasNumber:  s.aliasNumber,
+		URLs:         make([]entry, 0, len(s.urls)),
+		Clicks:       slices.Clone(s.clicks),
+		UTMTemplates: maps.Clone(s.utmTemplates),
+	}
+	for _, e := range s.urls {
+		snap.URLs = append(snap.URLs, e)
+	}
+	s.mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("%s: encode snapshot: %w", op, err)
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated snapshot.
+	tmp, err := os.CreateTemp(filepath.Dir(s.snapshotPath), ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.snapshotPath); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,359 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"urlShortener/internal/storage"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+var ErrSchemaTooNew = errors.New("database schema is newer than the binary")
+
+// Dialect describes the SQL flavour a set of migrations is written for.
+type Dialect struct {
+	dir         string
+	placeholder string
+	// lowerAlias folds the alias column the way storage.CanonicalAlias does.
+	lowerAlias string
+	// lock and unlock take and release a session lock that keeps other
+	// processes from migrating at the same time. Empty means no locking.
+	lock   string
+	unlock string
+}
+
+// lockKey names the advisory lock taken while migrating Postgres.
+const lockKey = "8388542170"
+
+var (
+	SQLite   = Dialect{dir: "sqlite", placeholder: "?", lowerAlias: "lower(alias)"}
+	Postgres = Dialect{
+		dir:         "postgres",
+		placeholder: "$1",
+		lowerAlias:  `lower(alias COLLATE "C")`,
+		lock:        "SELECT pg_advisory_lock(" + lockKey + ")",
+		unlock:      "SELECT pg_advisory_unlock(" + lockKey + ")",
+	}
+)
+
+// maxReportedConflicts bounds how many conflicting aliases KeyAliases names.
+const maxReportedConflicts = 10
+
+// fileName matches migration files such as 0001_create_url.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator applies the embedded migrations of a dialect to a database.
+// Applied versions are recorded in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []migration
+}
+
+func New(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	const op = "storage.migrations.New"
+
+	entries, err := fs.ReadDir(files, dialect.dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	byVersion := make(map[int]*migration)
+
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("%s: unexpected file %s", op, e.Name())
+		}
+
+		version, _ := strconv.Atoi(m[1])
+
+		data, err := fs.ReadFile(files, path.Join(dialect.dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{version: version, name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.name != m[2] {
+			return nil, fmt.Errorf("%s: conflicting names for version %d", op, version)
+		}
+
+		if m[3] == "up" {
+			mig.up = string(data)
+		} else {
+			mig.down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.up == "" || mig.down == "" {
+			return nil, fmt.Errorf("%s: migration %d has no up or down file", op, mig.version)
+		}
+		migrations = append(migrations, *mig)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Latest returns the newest schema version known to the binary.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].version
+}
+
+// Version returns the schema version the database is currently at.
+func (m *Migrator) Version() (int, error) {
+	const op = "storage.migrations.Version"
+
+	if err := m.ensureTable(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var version int
+	err := m.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, nil
+}
+
+// Check returns ErrSchemaTooNew if the database has migrations applied
+// that this binary does not know about.
+func (m *Migrator) Check() error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+
+	if version > m.Latest() {
+		return fmt.Errorf("%w: database at version %d, binary supports up to %d", ErrSchemaTooNew, version, m.Latest())
+	}
+
+	return nil
+}
+
+// Up applies all pending migrations and returns how many were applied.
+// Replicas starting together wait for each other, so only the first one
+// applies anything.
+func (m *Migrator) Up() (int, error) {
+	const op = "storage.migrations.Up"
+
+	unlock, err := m.acquire()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	if err := m.Check(); err != nil {
+		return 0, err
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+
+	for _, mig := range m.migrations {
+		if mig.version <= version {
+			continue
+		}
+
+		insert := "INSERT INTO schema_migrations (version) VALUES (" + m.dialect.placeholder + ")"
+		if err := m.apply(mig.up, insert, mig.version); err != nil {
+			return applied, fmt.Errorf("%s: migration %d_%s: %w", op, mig.version, mig.name, err)
+		}
+
+		applied++
+	}
+
+	return applied, nil
+}
+
+// Down rolls back up to steps most recently applied migrations and returns
+// how many were rolled back.
+func (m *Migrator) Down(steps int) (int, error) {
+	const op = "storage.migrations.Down"
+
+	unlock, err := m.acquire()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	if err := m.Check(); err != nil {
+		return 0, err
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+
+	for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+		mig := m.migrations[i]
+		if mig.version > version {
+			continue
+		}
+
+		remove := "DELETE FROM schema_migrations WHERE version = " + m.dialect.placeholder
+		if err := m.apply(mig.down, remove, mig.version); err != nil {
+			return reverted, fmt.Errorf("%s: migration %d_%s: %w", op, mig.version, mig.name, err)
+		}
+
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// KeyAliases sets the key links are looked up by: the canonical form of
+// the alias if caseInsensitive is set, and the alias itself otherwise.
+// Stored aliases are left as they are, so the setting can be turned off
+// again. If aliases differ only in case, turning it on changes nothing and
+// an error wrapping storage.ErrAliasConflict names them. It takes the
+// migration lock, so it does not interleave with another process migrating.
+func (m *Migrator) KeyAliases(caseInsensitive bool) error {
+	const op = "storage.migrations.KeyAliases"
+
+	unlock, err := m.acquire()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	key := "alias"
+	if caseInsensitive {
+		key = m.dialect.lowerAlias
+
+		rows, err := tx.Query("SELECT " + key + " FROM url GROUP BY " + key +
+			" HAVING COUNT(*) > 1 ORDER BY 1 LIMIT " + strconv.Itoa(maxReportedConflicts))
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		var conflicts []string
+		for rows.Next() {
+			var alias string
+			if err := rows.Scan(&alias); err != nil {
+				rows.Close()
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			conflicts = append(conflicts, alias)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if len(conflicts) > 0 {
+			return fmt.Errorf("%s: %w: %s", op, storage.ErrAliasConflict, strings.Join(conflicts, ", "))
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE url SET alias_key = " + key + " WHERE alias_key <> " + key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// acquire takes the dialect's migration lock and returns the function that
+// releases it. The lock belongs to a session, so it is held on a connection
+// of its own until then.
+func (m *Migrator) acquire() (func(), error) {
+	if m.dialect.lock == "" {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.lock); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+
+	return func() {
+		// Closing alone would return the connection to the pool still
+		// holding the lock.
+		conn.ExecContext(ctx, m.dialect.unlock)
+		conn.Close()
+	}, nil
+}
+
+// apply runs a migration script and records the version change in one transaction.
+func (m *Migrator) apply(script, record string, version int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(record, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) ensureTable() error {
+	_, err := m.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY);
+	`)
+
+	return err
+}
@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func newTestMigrator(t *testing.T) (*Migrator, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "storage.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, SQLite)
+	require.NoError(t, err)
+
+	return m, db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	require.NoError(t, err)
+
+	return count > 0
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	m, db := newTestMigrator(t)
+
+	version, err := m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	applied, err := m.Up()
+	require.NoError(t, err)
+	assert.Equal(t, len(m.migrations), applied)
+	assert.True(t, tableExists(t, db, "url"))
+
+	version, err = m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, m.Latest(), version)
+
+	// Running again is a no-op.
+	applied, err = m.Up()
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	reverted, err := m.Down(len(m.migrations))
+	require.NoError(t, err)
+	assert.Equal(t, len(m.migrations), reverted)
+	assert.False(t, tableExists(t, db, "url"))
+
+	version, err = m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+}
+
+func TestMigrator_SchemaTooNew(t *testing.T) {
+	m, db := newTestMigrator(t)
+
+	_, err := m.Up()
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO schema_migrations (version) VALUES (?)", m.Latest()+1)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, m.Check(), ErrSchemaTooNew)
+
+	_, err = m.Up()
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
+
+func TestNew_AllDialects(t *testing.T) {
+	for _, d := range []Dialect{SQLite, Postgres} {
+		m, err := New(nil, d)
+		require.NoError(t, err, d.dir)
+		assert.Positive(t, m.Latest(), d.dir)
+
+		for i, mig := range m.migrations {
+			assert.Equal(t, i+1, mig.version, "%s migrations must be numbered without gaps", d.dir)
+		}
+	}
+}
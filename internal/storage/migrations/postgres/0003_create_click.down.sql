@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_click_alias_clicked_at;
+DROP TABLE IF EXISTS click;
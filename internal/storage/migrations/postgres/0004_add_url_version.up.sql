@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
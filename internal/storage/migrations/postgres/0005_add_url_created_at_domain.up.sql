@@ -0,0 +1,10 @@
+ALTER TABLE url ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE url ADD COLUMN domain TEXT NOT NULL DEFAULT '';
+
+UPDATE url SET domain = lower(coalesce(
+	substring(url from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^:/?#]*)'),
+	''
+));
+
+CREATE INDEX IF NOT EXISTS idx_url_created_at ON url (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_url_domain ON url (domain);
@@ -0,0 +1,4 @@
+CREATE SEQUENCE IF NOT EXISTS alias_number;
+
+-- Counting on from the newest link keeps clear of aliases handed out before.
+SELECT setval('alias_number', COALESCE((SELECT MAX(id) FROM url), 0) + 1, false);
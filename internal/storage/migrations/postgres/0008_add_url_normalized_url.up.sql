@@ -0,0 +1,6 @@
+ALTER TABLE url ADD COLUMN normalized_url TEXT NOT NULL DEFAULT '';
+
+-- Existing links are matched as stored; new ones get the canonical form from the application.
+UPDATE url SET normalized_url = url;
+
+CREATE INDEX IF NOT EXISTS idx_url_normalized_url ON url (normalized_url);
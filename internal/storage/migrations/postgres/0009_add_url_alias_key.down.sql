@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_url_alias_key;
+ALTER TABLE url DROP COLUMN alias_key;
@@ -0,0 +1,8 @@
+ALTER TABLE url ADD COLUMN alias_key TEXT NOT NULL DEFAULT '';
+
+-- Links are looked up by alias_key, which is the alias folded to lower case
+-- when aliases are case-insensitive and the alias itself otherwise. The
+-- application keeps it in step with that setting.
+UPDATE url SET alias_key = alias;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_url_alias_key ON url (alias_key);
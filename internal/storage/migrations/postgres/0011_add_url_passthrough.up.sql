@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN passthrough BOOLEAN NOT NULL DEFAULT FALSE;
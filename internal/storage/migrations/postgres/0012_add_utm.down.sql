@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS utm_template;
+ALTER TABLE url DROP COLUMN utm_template;
+ALTER TABLE url DROP COLUMN utm;
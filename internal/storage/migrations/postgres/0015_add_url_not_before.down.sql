@@ -0,0 +1,2 @@
+ALTER TABLE url DROP COLUMN fallback_url;
+ALTER TABLE url DROP COLUMN not_before;
@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN targets TEXT NOT NULL DEFAULT '';
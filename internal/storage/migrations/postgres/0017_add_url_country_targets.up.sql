@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN country_targets TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
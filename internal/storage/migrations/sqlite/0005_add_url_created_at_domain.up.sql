@@ -0,0 +1,16 @@
+ALTER TABLE url ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE url ADD COLUMN domain TEXT NOT NULL DEFAULT '';
+
+-- Links created before this migration get the migration time as creation time.
+UPDATE url SET created_at = CAST(strftime('%s', 'now') AS INTEGER);
+
+-- Host part of the destination: the text after "://" up to the first of / ? # :
+UPDATE url SET domain = lower(substr(
+	replace(replace(replace(substr(url, instr(url, '://') + 3), '?', '/'), '#', '/'), ':', '/') || '/',
+	1,
+	instr(replace(replace(replace(substr(url, instr(url, '://') + 3), '?', '/'), '#', '/'), ':', '/') || '/', '/') - 1
+))
+WHERE instr(url, '://') > 0;
+
+CREATE INDEX IF NOT EXISTS idx_url_created_at ON url (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_url_domain ON url (domain);
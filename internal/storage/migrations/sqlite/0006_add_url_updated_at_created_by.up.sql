@@ -0,0 +1,2 @@
+ALTER TABLE url ADD COLUMN updated_at INTEGER;
+ALTER TABLE url ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
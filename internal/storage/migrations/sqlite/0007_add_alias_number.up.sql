@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS alias_number (next INTEGER NOT NULL);
+
+-- Counting on from the newest link keeps clear of aliases handed out before.
+INSERT INTO alias_number (next) SELECT COALESCE(MAX(id), 0) + 1 FROM url;
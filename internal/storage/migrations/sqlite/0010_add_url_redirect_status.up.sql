@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN redirect_status INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN passthrough INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+ALTER TABLE url ADD COLUMN utm TEXT NOT NULL DEFAULT '';
+ALTER TABLE url ADD COLUMN utm_template TEXT NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS utm_template (
+	name TEXT PRIMARY KEY,
+	utm TEXT NOT NULL
+);
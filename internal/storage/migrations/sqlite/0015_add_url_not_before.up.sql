@@ -0,0 +1,2 @@
+ALTER TABLE url ADD COLUMN not_before INTEGER;
+ALTER TABLE url ADD COLUMN fallback_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,721 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"urlShortener/internal/storage"
+	"urlShortener/internal/storage/migrations"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// uniqueViolation is the SQLSTATE code reported for unique constraint violations.
+const uniqueViolation = "23505"
+
+type Storage struct {
+	db   *sql.DB
+	opts storage.Options
+}
+
+func New(dsn string, opts storage.Options) (*Storage, error) {
+	const op = "storage.postgres.New"
+
+	db, err := Open(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := migrations.New(db, migrations.Postgres)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := m.Up(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.KeyAliases(opts.CaseInsensitiveAliases); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{db: db, opts: opts}, nil
+}
+
+// Open connects to the database without touching its schema.
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (s *Storage) SaveURL(ctx context.Context, urlToSave string, alias string, opts storage.URLOptions) (int64, error) {
+	const op = "storage.postgres.SaveURL"
+
+	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO url (url, alias, alias_key, expires_at, domain, created_by, normalized_url, redirect_status, passthrough, utm, utm_template, password_hash, max_clicks, clicks_left, not_before, fallback_url, targets, country_targets) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) RETURNING id")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var id int64
+	err = stmt.QueryRowContext(ctx, urlToSave, alias, s.opts.Alias(alias), opts.ExpiresAt, storage.DomainOf(urlToSave), opts.CreatedBy, storage.NormalizeURL(urlToSave), opts.RedirectStatus, opts.Passthrough, opts.UTM.Encode(), opts.UTMTemplate, opts.PasswordHash, opts.MaxClicks, opts.MaxClicks, opts.NotBefore, opts.FallbackURL, storage.EncodeTargets(opts.Targets), storage.EncodeCountryTargets(opts.CountryTargets)).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return 0, storage.ErrURLExists
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// SaveURLs inserts a batch of links in a single transaction. Taken aliases
+// are reported per link; with atomic set, the first one rolls the whole
+// batch back and is returned as a *storage.BatchError.
+func (s *Storage) SaveURLs(ctx context.Context, urls []storage.NewURL, atomic bool) ([]storage.SaveResult, error) {
+	const op = "storage.postgres.SaveURLs"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// ON CONFLICT keeps the transaction usable after a taken alias.
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO url (url, alias, alias_key, expires_at, domain, created_by, normalized_url, redirect_status, passthrough, utm, utm_template, password_hash, max_clicks, clicks_left, not_before, fallback_url, targets, country_targets) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) ON CONFLICT DO NOTHING RETURNING id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	results := make([]storage.SaveResult, len(urls))
+	for i, u := range urls {
+		err := stmt.QueryRowContext(ctx, u.URL, u.Alias, s.opts.Alias(u.Alias), u.ExpiresAt, storage.DomainOf(u.URL), u.CreatedBy, storage.NormalizeURL(u.URL), u.RedirectStatus, u.Passthrough, u.UTM.Encode(), u.UTMTemplate, u.PasswordHash, u.MaxClicks, u.MaxClicks, u.NotBefore, u.FallbackURL, storage.EncodeTargets(u.Targets), storage.EncodeCountryTargets(u.CountryTargets)).Scan(&results[i].ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			if atomic {
+				return nil, &storage.BatchError{Index: i, Err: storage.ErrURLExists}
+			}
+			results[i].Err = storage.ErrURLExists
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return results, nil
+}
+
+// GetURL returns the link stored under alias, or storage.ErrURLExpired
+// once its expiry time has passed.
+func (s *Storage) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	const op = "storage.postgres.GetURL"
+
+	alias = s.opts.Alias(alias)
+
+	row := s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE alias_key = $1", alias)
+
+	u, err := scanURL(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if u.ExpiresAt != nil && !u.ExpiresAt.After(time.Now()) {
+		return storage.URL{}, storage.ErrURLExpired
+	}
+
+	if u.Exhausted() {
+		return storage.URL{}, storage.ErrURLExhausted
+	}
+
+	return u, nil
+}
+
+// GetURLInfo returns the stored record for alias, expired or not.
+func (s *Storage) GetURLInfo(ctx context.Context, alias string) (storage.URL, error) {
+	const op = "storage.postgres.GetURLInfo"
+
+	alias = s.opts.Alias(alias)
+
+	row := s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE alias_key = $1", alias)
+
+	u, err := scanURL(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return u, nil
+}
+
+// GetURLByDestination returns the oldest link to urlToSave, compared in
+// normalized form, that is active at once, never expires and has no
+// password, click limit, targets, redirect status of its own, passthrough
+// or UTM parameters.
+func (s *Storage) GetURLByDestination(ctx context.Context, urlToSave string) (storage.URL, error) {
+	const op = "storage.postgres.GetURLByDestination"
+
+	row := s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE normalized_url = $1 AND expires_at IS NULL AND password_hash = '' AND max_clicks = 0 AND not_before IS NULL AND targets = '' AND country_targets = '' AND redirect_status = 0 AND passthrough = FALSE AND utm = '' AND utm_template = '' ORDER BY id LIMIT 1",
+		storage.NormalizeURL(urlToSave))
+
+	u, err := scanURL(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return u, nil
+}
+
+// DeleteURL removes the link stored under alias and its clicks.
+func (s *Storage) DeleteURL(ctx context.Context, alias string) error {
+	const op = "storage.postgres.DeleteURL"
+
+	alias = s.opts.Alias(alias)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteLinks(ctx, tx, "alias_key = $1", alias)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(deleted) == 0 {
+		return storage.ErrURLNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteURLs removes every link matching q, and their clicks, in a single
+// transaction.
+func (s *Storage) DeleteURLs(ctx context.Context, q storage.DeleteQuery) (storage.DeleteResult, error) {
+	const op = "storage.postgres.DeleteURLs"
+
+	var res storage.DeleteResult
+
+	if q.Empty() {
+		return res, nil
+	}
+
+	keys := s.opts.Aliases(q.Aliases)
+
+	var where []string
+	var args []any
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(keys) > 0 {
+		where = append(where, "alias_key = ANY("+arg(keys)+")")
+	}
+	if q.CreatedBefore != nil {
+		where = append(where, "created_at < "+arg(*q.CreatedBefore))
+	}
+	if q.Domain != "" {
+		where = append(where, "domain = "+arg(strings.ToLower(q.Domain)))
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteLinks(ctx, tx, strings.Join(where, " AND "), args...)
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res.Deleted = int64(len(deleted))
+	res.NotFound = q.Missing(deleted, s.opts.Alias)
+
+	return res, nil
+}
+
+// deleteLinks removes the links matching where, and the clicks recorded on
+// them, as part of tx. It returns the alias keys of the removed links.
+func deleteLinks(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "DELETE FROM url WHERE "+where+" RETURNING alias, alias_key", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted, keys []string
+	for rows.Next() {
+		var alias, key string
+		if err := rows.Scan(&alias, &key); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, alias)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM click WHERE alias = ANY($1)", deleted); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// UpdateURL points alias at newURL and returns the link's new version.
+// If version is not zero, the update only happens while the link is still
+// at that version; otherwise storage.ErrVersionMismatch is returned.
+func (s *Storage) UpdateURL(ctx context.Context, alias string, newURL string, version int64) (int64, error) {
+	const op = "storage.postgres.UpdateURL"
+
+	alias = s.opts.Alias(alias)
+
+	stmt, err := s.db.PrepareContext(ctx, `
+	UPDATE url SET url = $1, domain = $4, normalized_url = $5, updated_at = now(), version = version + 1
+	WHERE alias_key = $2 AND ($3::BIGINT = 0 OR version = $3)
+	RETURNING version`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var newVersion int64
+	err = stmt.QueryRowContext(ctx, newURL, alias, version, storage.DomainOf(newURL), storage.NormalizeURL(newURL)).Scan(&newVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, s.missingOr(ctx, alias, storage.ErrVersionMismatch)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return newVersion, nil
+}
+
+// missingOr tells why a conditional write on alias matched no rows: either
+// the link is gone, or reason.
+func (s *Storage) missingOr(ctx context.Context, alias string, reason error) error {
+	const op = "storage.postgres.missingOr"
+
+	var exists int
+	err := s.db.QueryRowContext(ctx, "SELECT 1 FROM url WHERE alias_key = $1", alias).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrURLNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return reason
+}
+
+// ConsumeClick takes one of the visits left to a link with MaxClicks set.
+// It returns storage.ErrURLExhausted when none are left, which is always
+// the case for links without a limit. The check and the decrement are a
+// single statement, so concurrent visits cannot overdraw the limit.
+func (s *Storage) ConsumeClick(ctx context.Context, alias string) error {
+	const op = "storage.postgres.ConsumeClick"
+
+	alias = s.opts.Alias(alias)
+
+	var left int64
+	err := s.db.QueryRowContext(ctx, "UPDATE url SET clicks_left = clicks_left - 1 WHERE alias_key = $1 AND clicks_left > 0 RETURNING clicks_left", alias).Scan(&left)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.missingOr(ctx, alias, storage.ErrURLExhausted)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListURLs returns one page of links matching q.
+func (s *Storage) ListURLs(ctx context.Context, q storage.ListQuery) ([]storage.URL, error) {
+	const op = "storage.postgres.ListURLs"
+
+	var where []string
+	var args []any
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	q.AliasPrefix = s.opts.Alias(q.AliasPrefix)
+
+	if q.AliasPrefix != "" {
+		where = append(where, "starts_with(alias_key, "+arg(q.AliasPrefix)+")")
+	}
+	if q.URLContains != "" {
+		where = append(where, "strpos(lower(url), lower("+arg(q.URLContains)+")) > 0")
+	}
+	if q.Domain != "" {
+		where = append(where, "domain = "+arg(strings.ToLower(q.Domain)))
+	}
+
+	cmp, dir := ">", "ASC"
+	if q.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	var orderBy string
+	switch q.SortBy {
+	case storage.SortByAlias:
+		orderBy = "alias " + dir
+		if q.After != nil {
+			where = append(where, "alias "+cmp+" "+arg(q.After.Alias))
+		}
+	default:
+		orderBy = "created_at " + dir + ", id " + dir
+		if q.After != nil {
+			where = append(where, "(created_at, id) "+cmp+" ("+arg(q.After.CreatedAt)+", "+arg(q.After.ID)+")")
+		}
+	}
+
+	query := "SELECT " + urlColumns + " FROM url"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + orderBy + " LIMIT " + arg(q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var urls []storage.URL
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		urls = append(urls, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return urls, nil
+}
+
+// urlColumns are the columns scanURL reads, in order.
+const urlColumns = "id, alias, url, created_at, updated_at, version, expires_at, created_by, redirect_status, passthrough, utm, utm_template, password_hash, max_clicks, clicks_left, not_before, fallback_url, targets, country_targets"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanURL(row scanner) (storage.URL, error) {
+	var u storage.URL
+	var updatedAt, expiresAt, notBefore sql.NullTime
+	var utm, targets, countryTargets string
+
+	if err := row.Scan(&u.ID, &u.Alias, &u.URL, &u.CreatedAt, &updatedAt, &u.Version, &expiresAt, &u.CreatedBy, &u.RedirectStatus, &u.Passthrough, &utm, &u.UTMTemplate, &u.PasswordHash, &u.MaxClicks, &u.ClicksLeft, &notBefore, &u.FallbackURL, &targets, &countryTargets); err != nil {
+		return u, err
+	}
+
+	u.UTM = storage.ParseUTM(utm)
+	u.Targets = storage.ParseTargets(targets)
+	u.CountryTargets = storage.ParseCountryTargets(countryTargets)
+	u.CreatedAt = u.CreatedAt.UTC()
+	u.UpdatedAt = fromNullTime(updatedAt)
+	u.ExpiresAt = fromNullTime(expiresAt)
+	u.NotBefore = fromNullTime(notBefore)
+
+	return u, nil
+}
+
+func fromNullTime(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := v.Time.UTC()
+	return &t
+}
+
+// NextAliasNumber returns a number for a generated alias. Numbers are never
+// handed out twice, also not to other instances sharing the database.
+func (s *Storage) NextAliasNumber(ctx context.Context) (int64, error) {
+	const op = "storage.postgres.NextAliasNumber"
+
+	var n int64
+	if err := s.db.QueryRowContext(ctx, "SELECT nextval('alias_number')").Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// SaveUTMTemplate creates the template t or replaces the one of that name.
+func (s *Storage) SaveUTMTemplate(ctx context.Context, t storage.UTMTemplate) error {
+	const op = "storage.postgres.SaveUTMTemplate"
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO utm_template (name, utm) VALUES ($1, $2)
+	ON CONFLICT (name) DO UPDATE SET utm = excluded.utm`,
+		t.Name, t.UTM.Encode(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetUTMTemplate(ctx context.Context, name string) (storage.UTMTemplate, error) {
+	const op = "storage.postgres.GetUTMTemplate"
+
+	var utm string
+	err := s.db.QueryRowContext(ctx, "SELECT utm FROM utm_template WHERE name = $1", name).Scan(&utm)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.UTMTemplate{}, storage.ErrUTMTemplateNotFound
+	}
+	if err != nil {
+		return storage.UTMTemplate{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return storage.UTMTemplate{Name: name, UTM: storage.ParseUTM(utm)}, nil
+}
+
+// ListUTMTemplates returns all templates ordered by name.
+func (s *Storage) ListUTMTemplates(ctx context.Context) ([]storage.UTMTemplate, error) {
+	const op = "storage.postgres.ListUTMTemplates"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name, utm FROM utm_template ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var templates []storage.UTMTemplate
+	for rows.Next() {
+		var t storage.UTMTemplate
+		var utm string
+		if err := rows.Scan(&t.Name, &utm); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		t.UTM = storage.ParseUTM(utm)
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return templates, nil
+}
+
+// DeleteUTMTemplate removes the named template. Links using it keep their
+// own UTM parameters.
+func (s *Storage) DeleteUTMTemplate(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeleteUTMTemplate"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM utm_template WHERE name = $1", name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if rowsAffected == 0 {
+		return storage.ErrUTMTemplateNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpiredURLs removes all links that expired at or before before,
+// and their clicks.
+func (s *Storage) DeleteExpiredURLs(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.postgres.DeleteExpiredURLs"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteLinks(ctx, tx, "expires_at <= $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int64(len(deleted)), nil
+}
+
+// SaveClicks stores a batch of click events in a single transaction. Clicks
+// on links deleted meanwhile are dropped, so that a new link under the same
+// alias starts without them.
+func (s *Storage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	const op = "storage.postgres.SaveClicks"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO click (alias, clicked_at, referrer, user_agent, ip_hash) SELECT alias, $1::timestamptz, $2::text, $3::text, $4::text FROM url WHERE alias_key = $5")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		_, err := stmt.ExecContext(ctx, c.ClickedAt, c.Referrer, c.UserAgent, c.IPHash, s.opts.Alias(c.Alias))
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ClickStats aggregates the clicks on alias selected by q.
+func (s *Storage) ClickStats(ctx context.Context, alias string, q storage.StatsQuery) (storage.ClickStats, error) {
+	const op = "storage.postgres.ClickStats"
+
+	alias = s.opts.Alias(alias)
+
+	var stats storage.ClickStats
+
+	// Clicks are recorded under the alias as stored.
+	err := s.db.QueryRowContext(ctx, "SELECT alias FROM url WHERE alias_key = $1", alias).Scan(&alias)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stats, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return stats, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+	SELECT COUNT(*), COUNT(DISTINCT NULLIF(ip_hash, ''))
+	FROM click WHERE alias = $1 AND clicked_at >= $2 AND clicked_at < $3`,
+		alias, q.From, q.To,
+	).Scan(&stats.Total, &stats.Unique)
+	if err != nil {
+		return stats, fmt.Errorf("%s: totals: %w", op, err)
+	}
+
+	// date_trunc aligns weeks to Monday, matching storage.Bucket.Truncate.
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT date_trunc($1, clicked_at AT TIME ZONE 'UTC') AS bucket, COUNT(*)
+	FROM click WHERE alias = $2 AND clicked_at >= $3 AND clicked_at < $4
+	GROUP BY bucket ORDER BY bucket`,
+		string(q.Bucket), alias, q.From, q.To,
+	)
+	if err != nil {
+		return stats, fmt.Errorf("%s: series: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bc storage.BucketCount
+		if err := rows.Scan(&bc.Start, &bc.Clicks); err != nil {
+			return stats, fmt.Errorf("%s: series: %w", op, err)
+		}
+		bc.Start = bc.Start.UTC()
+		stats.Series = append(stats.Series, bc)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("%s: series: %w", op, err)
+	}
+
+	stats.TopReferrers, err = s.topClickValues(ctx, "referrer", alias, q)
+	if err != nil {
+		return stats, fmt.Errorf("%s: referrers: %w", op, err)
+	}
+
+	stats.TopUserAgents, err = s.topClickValues(ctx, "user_agent", alias, q)
+	if err != nil {
+		return stats, fmt.Errorf("%s: user agents: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// topClickValues ranks the non-empty values of a click column by frequency.
+// column is always one of our own column names, never user input.
+func (s *Storage) topClickValues(ctx context.Context, column, alias string, q storage.StatsQuery) ([]storage.ValueCount, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+column+`, COUNT(*) AS clicks
+	FROM click WHERE alias = $1 AND clicked_at >= $2 AND clicked_at < $3 AND `+column+` != ''
+	GROUP BY `+column+` ORDER BY clicks DESC, `+column+` LIMIT $4`,
+		alias, q.From, q.To, q.Top,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []storage.ValueCount
+	for rows.Next() {
+		var vc storage.ValueCount
+		if err := rows.Scan(&vc.Value, &vc.Clicks); err != nil {
+			return nil, err
+		}
+		values = append(values, vc)
+	}
+
+	return values, rows.Err()
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,317 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"urlShortener/internal/storage"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStorage connects to the database from TEST_POSTGRES_DSN. Any
+// Postgres-compatible server from version 12 on works; `make test-postgres`
+// starts the one from docker-compose.yml and runs the tests against it, as
+// CI does.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	s, err := New(dsn, storage.Options{})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { s.db.Close() })
+
+	return s
+}
+
+func TestNew_Concurrent(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	// Replicas starting together on a fresh database must not trip over
+	// each other's migrations.
+	errs := make(chan error, 4)
+	for range cap(errs) {
+		go func() {
+			s, err := New(dsn, storage.Options{})
+			if err == nil {
+				s.db.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	for range cap(errs) {
+		assert.NoError(t, <-errs)
+	}
+}
+
+func TestStorage_SaveGetDelete(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	url := gofakeit.URL()
+	alias := gofakeit.LetterN(12)
+
+	id, err := s.SaveURL(ctx, url, alias, storage.URLOptions{})
+	require.NoError(t, err)
+	assert.Positive(t, id)
+
+	got, err := s.GetURL(ctx, alias)
+	require.NoError(t, err)
+	assert.Equal(t, url, got.URL)
+
+	require.NoError(t, s.DeleteURL(ctx, alias))
+
+	_, err = s.GetURL(ctx, alias)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_SaveURL_Exists(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	alias := gofakeit.LetterN(12)
+
+	_, err := s.SaveURL(ctx, gofakeit.URL(), alias, storage.URLOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.DeleteURL(ctx, alias) })
+
+	_, err = s.SaveURL(ctx, gofakeit.URL(), alias, storage.URLOptions{})
+	assert.ErrorIs(t, err, storage.ErrURLExists)
+}
+
+func TestStorage_NotFound(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	_, err := s.GetURL(ctx, "nonexistent-"+gofakeit.LetterN(8))
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	err = s.DeleteURL(ctx, "nonexistent-"+gofakeit.LetterN(8))
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_Expiry(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	past := time.Now().Add(-time.Minute)
+	alias := gofakeit.LetterN(12)
+
+	_, err := s.SaveURL(ctx, gofakeit.URL(), alias, storage.URLOptions{ExpiresAt: &past})
+	require.NoError(t, err)
+
+	_, err = s.GetURL(ctx, alias)
+	assert.ErrorIs(t, err, storage.ErrURLExpired)
+
+	// Still within the grace period.
+	_, err = s.DeleteExpiredURLs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+
+	_, err = s.GetURL(ctx, alias)
+	assert.ErrorIs(t, err, storage.ErrURLExpired)
+
+	deleted, err := s.DeleteExpiredURLs(ctx, time.Now())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, int64(1))
+
+	_, err = s.GetURL(ctx, alias)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_GetURLInfo(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	url := gofakeit.URL()
+	alias := gofakeit.LetterN(12)
+
+	id, err := s.SaveURL(ctx, url, alias, storage.URLOptions{CreatedBy: "alice"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.DeleteURL(ctx, alias) })
+
+	u, err := s.GetURLInfo(ctx, alias)
+	require.NoError(t, err)
+	assert.Equal(t, id, u.ID)
+	assert.Equal(t, url, u.URL)
+	assert.Equal(t, "alice", u.CreatedBy)
+	assert.Nil(t, u.UpdatedAt)
+
+	_, err = s.UpdateURL(ctx, alias, gofakeit.URL(), 0)
+	require.NoError(t, err)
+
+	u, err = s.GetURLInfo(ctx, alias)
+	require.NoError(t, err)
+	assert.NotNil(t, u.UpdatedAt)
+
+	_, err = s.GetURLInfo(ctx, "nonexistent-"+gofakeit.LetterN(8))
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_SaveURLs(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	taken := gofakeit.LetterN(12)
+	_, err := s.SaveURL(ctx, gofakeit.URL(), taken, storage.URLOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.DeleteURL(ctx, taken) })
+
+	fresh := gofakeit.LetterN(12)
+	t.Cleanup(func() { _ = s.DeleteURL(ctx, fresh) })
+
+	_, err = s.SaveURLs(ctx, []storage.NewURL{
+		{URL: gofakeit.URL(), Alias: fresh},
+		{URL: gofakeit.URL(), Alias: taken},
+	}, true)
+	var batchErr *storage.BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+
+	_, err = s.GetURL(ctx, fresh)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	results, err := s.SaveURLs(ctx, []storage.NewURL{
+		{URL: gofakeit.URL(), Alias: fresh},
+		{URL: gofakeit.URL(), Alias: taken},
+	}, false)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, storage.ErrURLExists)
+}
+
+func TestStorage_DeleteURLs(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	domain := strings.ToLower(gofakeit.LetterN(12)) + ".example"
+	aliases := []string{gofakeit.LetterN(12), gofakeit.LetterN(12), gofakeit.LetterN(12)}
+	for _, alias := range aliases {
+		_, err := s.SaveURL(ctx, "https://"+domain+"/"+alias, alias, storage.URLOptions{})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.DeleteURL(ctx, alias) })
+	}
+
+	missing := "nonexistent-" + gofakeit.LetterN(8)
+	res, err := s.DeleteURLs(ctx, storage.DeleteQuery{Aliases: []string{aliases[0], missing}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.Deleted)
+	assert.Equal(t, []string{missing}, res.NotFound)
+
+	res, err = s.DeleteURLs(ctx, storage.DeleteQuery{Domain: strings.ToUpper(domain)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Deleted)
+}
+
+func TestStorage_DeleteRemovesClicks(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	past := time.Now().Add(-time.Hour)
+	aliases := []string{gofakeit.LetterN(12), gofakeit.LetterN(12), gofakeit.LetterN(12)}
+	for i, alias := range aliases {
+		opts := storage.URLOptions{}
+		if i == 2 {
+			opts.ExpiresAt = &past
+		}
+		_, err := s.SaveURL(ctx, "https://google.com", alias, opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.DeleteURL(ctx, alias) })
+		require.NoError(t, s.SaveClicks(ctx, []storage.Click{{Alias: alias, ClickedAt: time.Now(), IPHash: "a"}}))
+	}
+
+	require.NoError(t, s.DeleteURL(ctx, aliases[0]))
+	_, err := s.DeleteURLs(ctx, storage.DeleteQuery{Aliases: []string{aliases[1]}})
+	require.NoError(t, err)
+	_, err = s.DeleteExpiredURLs(ctx, time.Now())
+	require.NoError(t, err)
+
+	var count int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM click WHERE alias = ANY($1)", aliases).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// A new link under a deleted alias starts without history.
+	_, err = s.SaveURL(ctx, "https://example.com", aliases[0], storage.URLOptions{})
+	require.NoError(t, err)
+
+	stats, err := s.ClickStats(ctx, aliases[0], storage.StatsQuery{From: past, To: time.Now().Add(time.Hour), Bucket: storage.BucketDay})
+	require.NoError(t, err)
+	assert.Zero(t, stats.Total)
+}
+
+func TestStorage_NextAliasNumber(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	a, err := s.NextAliasNumber(ctx)
+	require.NoError(t, err)
+
+	b, err := s.NextAliasNumber(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, b, a)
+}
+
+func TestStorage_ListURLs(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	prefix := gofakeit.LetterN(12) + "-"
+	for _, suffix := range []string{"a", "b", "c"} {
+		alias := prefix + suffix
+		_, err := s.SaveURL(ctx, "https://Example.com/"+suffix, alias, storage.URLOptions{})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.DeleteURL(ctx, alias) })
+	}
+
+	var aliases []string
+	q := storage.ListQuery{AliasPrefix: prefix, SortBy: storage.SortByCreatedAt, Desc: true, Limit: 2}
+	for {
+		urls, err := s.ListURLs(ctx, q)
+		require.NoError(t, err)
+		for _, u := range urls {
+			aliases = append(aliases, u.Alias)
+		}
+		if len(urls) < q.Limit {
+			break
+		}
+		last := urls[len(urls)-1]
+		q.After = &storage.ListCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	assert.Equal(t, []string{prefix + "c", prefix + "b", prefix + "a"}, aliases)
+
+	urls, err := s.ListURLs(ctx, storage.ListQuery{
+		AliasPrefix: prefix,
+		Domain:      "example.com",
+		URLContains: "/B",
+		SortBy:      storage.SortByAlias,
+		Limit:       10,
+	})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, prefix+"b", urls[0].Alias)
+}
@@ -1,56 +1,69 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 	"urlShortener/internal/storage"
+	"urlShortener/internal/storage/migrations"
 
 	_ "modernc.org/sqlite"
 )
 
 type Storage struct {
-	db *sql.DB
+	db   *sql.DB
+	opts storage.Options
 }
 
-func New(storagePath string) (*Storage, error) {
+func New(storagePath string, opts storage.Options) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	db, err := sql.Open("sqlite", storagePath)
+	db, err := Open(storagePath)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	stmt, err := db.Prepare(`
-	CREATE TABLE IF NOT EXISTS url (
-	id INTEGER PRIMARY KEY,
-	alias TEXT NOT NULL UNIQUE,
-	url TEXT NOT NULL);
-	CREATE INDEX IF NOT EXISTS idx_alias ON url (alias);
-	`)
-
+	m, err := migrations.New(db, migrations.SQLite)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = stmt.Exec()
-	if err != nil {
+	if _, err := m.Up(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.KeyAliases(opts.CaseInsensitiveAliases); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	return &Storage{db: db, opts: opts}, nil
+}
+
+// Open opens the database file without touching its schema.
+func Open(storagePath string) (*sql.DB, error) {
+	// Background writers (e.g. click analytics) share the file with request
+	// handlers, so wait for locks instead of failing with SQLITE_BUSY.
+	sep := "?"
+	if strings.Contains(storagePath, "?") {
+		sep = "&"
+	}
+
+	return sql.Open("sqlite", storagePath+sep+"_pragma=busy_timeout(5000)")
 }
 
-func (s *Storage) SaveURL(urlToSave string, alias string) (int64, error) {
+func (s *Storage) SaveURL(ctx context.Context, urlToSave string, alias string, opts storage.URLOptions) (int64, error) {
 	const op = "storage.sqlite.SaveUrl"
 
-	stmt, err := s.db.Prepare("INSERT INTO url (url, alias) VALUES (?, ?)")
+	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO url (url, alias, alias_key, expires_at, created_at, domain, created_by, normalized_url, redirect_status, passthrough, utm, utm_template, password_hash, max_clicks, clicks_left, not_before, fallback_url, targets, country_targets) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
+	defer stmt.Close()
 
-	res, err := stmt.Exec(urlToSave, alias)
+	res, err := stmt.ExecContext(ctx, urlToSave, alias, s.opts.Alias(alias), toUnix(opts.ExpiresAt), time.Now().Unix(), storage.DomainOf(urlToSave), opts.CreatedBy, storage.NormalizeURL(urlToSave), opts.RedirectStatus, opts.Passthrough, opts.UTM.Encode(), opts.UTMTemplate, opts.PasswordHash, opts.MaxClicks, opts.MaxClicks, toUnix(opts.NotBefore), opts.FallbackURL, storage.EncodeTargets(opts.Targets), storage.EncodeCountryTargets(opts.CountryTargets))
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 			return 0, storage.ErrURLExists
@@ -66,36 +79,483 @@ func (s *Storage) SaveURL(urlToSave string, alias string) (int64, error) {
 	return id, nil
 }
 
-func (s *Storage) GetURL(alias string) (string, error) {
+// SaveURLs inserts a batch of links in a single transaction. Taken aliases
+// are reported per link; with atomic set, the first one rolls the whole
+// batch back and is returned as a *storage.BatchError.
+func (s *Storage) SaveURLs(ctx context.Context, urls []storage.NewURL, atomic bool) ([]storage.SaveResult, error) {
+	const op = "storage.sqlite.SaveURLs"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// ON CONFLICT keeps the transaction usable after a taken alias.
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO url (url, alias, alias_key, expires_at, created_at, domain, created_by, normalized_url, redirect_status, passthrough, utm, utm_template, password_hash, max_clicks, clicks_left, not_before, fallback_url, targets, country_targets) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING RETURNING id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+
+	results := make([]storage.SaveResult, len(urls))
+	for i, u := range urls {
+		err := stmt.QueryRowContext(ctx, u.URL, u.Alias, s.opts.Alias(u.Alias), toUnix(u.ExpiresAt), now, storage.DomainOf(u.URL), u.CreatedBy, storage.NormalizeURL(u.URL), u.RedirectStatus, u.Passthrough, u.UTM.Encode(), u.UTMTemplate, u.PasswordHash, u.MaxClicks, u.MaxClicks, toUnix(u.NotBefore), u.FallbackURL, storage.EncodeTargets(u.Targets), storage.EncodeCountryTargets(u.CountryTargets)).Scan(&results[i].ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			if atomic {
+				return nil, &storage.BatchError{Index: i, Err: storage.ErrURLExists}
+			}
+			results[i].Err = storage.ErrURLExists
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return results, nil
+}
+
+// GetURL returns the link stored under alias, or storage.ErrURLExpired
+// once its expiry time has passed.
+func (s *Storage) GetURL(ctx context.Context, alias string) (storage.URL, error) {
 	const op = "storage.sqlite.GetUrl"
 
-	stmt, err := s.db.Prepare("SELECT url FROM url WHERE alias = ?")
+	alias = s.opts.Alias(alias)
+
+	row := s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE alias_key = ?", alias)
+
+	u, err := scanURL(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if u.ExpiresAt != nil && !u.ExpiresAt.After(time.Now()) {
+		return storage.URL{}, storage.ErrURLExpired
+	}
+
+	if u.Exhausted() {
+		return storage.URL{}, storage.ErrURLExhausted
+	}
+
+	return u, nil
+}
+
+// GetURLInfo returns the stored record for alias, expired or not.
+func (s *Storage) GetURLInfo(ctx context.Context, alias string) (storage.URL, error) {
+	const op = "storage.sqlite.GetURLInfo"
+
+	alias = s.opts.Alias(alias)
+
+	row := s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE alias_key = ?", alias)
+
+	u, err := scanURL(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, storage.ErrURLNotFound
+	}
 	if err != nil {
-		return "", fmt.Errorf("%s: prepare statement: %w", op, err)
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	var resURL string
-	err = stmt.QueryRow(alias).Scan(&resURL)
+	return u, nil
+}
+
+// GetURLByDestination returns the oldest link to urlToSave, compared in
+// normalized form, that is active at once, never expires and has no
+// password, click limit, targets, redirect status of its own, passthrough
+// or UTM parameters.
+func (s *Storage) GetURLByDestination(ctx context.Context, urlToSave string) (storage.URL, error) {
+	const op = "storage.sqlite.GetURLByDestination"
 
+	row := s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE normalized_url = ? AND expires_at IS NULL AND password_hash = '' AND max_clicks = 0 AND not_before IS NULL AND targets = '' AND country_targets = '' AND redirect_status = 0 AND passthrough = 0 AND utm = '' AND utm_template = '' ORDER BY id LIMIT 1",
+		storage.NormalizeURL(urlToSave))
+
+	u, err := scanURL(row)
 	if errors.Is(err, sql.ErrNoRows) {
-		return "", storage.ErrURLNotFound
+		return storage.URL{}, storage.ErrURLNotFound
 	}
 	if err != nil {
-		return "", fmt.Errorf("%s: execute query: %w", op, err)
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return resURL, nil
+	return u, nil
 }
 
-func (s *Storage) DeleteURL(alias string) error {
+// DeleteURL removes the link stored under alias and its clicks.
+func (s *Storage) DeleteURL(ctx context.Context, alias string) error {
 	const op = "storage.sqlite.DeleteURL"
 
-	stmt, err := s.db.Prepare("DELETE FROM url WHERE alias = ?")
+	alias = s.opts.Alias(alias)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteLinks(ctx, tx, "alias_key = ?", alias)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(deleted) == 0 {
+		return storage.ErrURLNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteURLs removes every link matching q, and their clicks, in a single
+// transaction.
+func (s *Storage) DeleteURLs(ctx context.Context, q storage.DeleteQuery) (storage.DeleteResult, error) {
+	const op = "storage.sqlite.DeleteURLs"
+
+	var res storage.DeleteResult
+
+	if q.Empty() {
+		return res, nil
+	}
+
+	keys := s.opts.Aliases(q.Aliases)
+
+	var where []string
+	var args []any
+
+	if len(keys) > 0 {
+		where = append(where, "alias_key IN (?"+strings.Repeat(", ?", len(keys)-1)+")")
+		for _, key := range keys {
+			args = append(args, key)
+		}
+	}
+	if q.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, q.CreatedBefore.Unix())
+	}
+	if q.Domain != "" {
+		where = append(where, "domain = ?")
+		args = append(args, strings.ToLower(q.Domain))
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteLinks(ctx, tx, strings.Join(where, " AND "), args...)
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res.Deleted = int64(len(deleted))
+	res.NotFound = q.Missing(deleted, s.opts.Alias)
+
+	return res, nil
+}
+
+// deleteLinks removes the links matching where, and the clicks recorded on
+// them, as part of tx. It returns the alias keys of the removed links.
+func deleteLinks(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "DELETE FROM url WHERE "+where+" RETURNING alias, alias_key", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted, keys []string
+	for rows.Next() {
+		var alias, key string
+		if err := rows.Scan(&alias, &key); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, alias)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM click WHERE alias = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	for _, alias := range deleted {
+		if _, err := stmt.ExecContext(ctx, alias); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// UpdateURL points alias at newURL and returns the link's new version.
+// If version is not zero, the update only happens while the link is still
+// at that version; otherwise storage.ErrVersionMismatch is returned.
+func (s *Storage) UpdateURL(ctx context.Context, alias string, newURL string, version int64) (int64, error) {
+	const op = "storage.sqlite.UpdateURL"
+
+	alias = s.opts.Alias(alias)
+
+	stmt, err := s.db.PrepareContext(ctx, `
+	UPDATE url SET url = ?1, domain = ?4, normalized_url = ?6, updated_at = ?5, version = version + 1
+	WHERE alias_key = ?2 AND (?3 = 0 OR version = ?3)
+	RETURNING version`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var newVersion int64
+	err = stmt.QueryRowContext(ctx, newURL, alias, version, storage.DomainOf(newURL), time.Now().Unix(), storage.NormalizeURL(newURL)).Scan(&newVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, s.missingOr(ctx, alias, storage.ErrVersionMismatch)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return newVersion, nil
+}
+
+// missingOr tells why a conditional write on alias matched no rows: either
+// the link is gone, or reason.
+func (s *Storage) missingOr(ctx context.Context, alias string, reason error) error {
+	const op = "storage.sqlite.missingOr"
+
+	var exists int
+	err := s.db.QueryRowContext(ctx, "SELECT 1 FROM url WHERE alias_key = ?", alias).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrURLNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return reason
+}
+
+// ConsumeClick takes one of the visits left to a link with MaxClicks set.
+// It returns storage.ErrURLExhausted when none are left, which is always
+// the case for links without a limit. The check and the decrement are a
+// single statement, so concurrent visits cannot overdraw the limit.
+func (s *Storage) ConsumeClick(ctx context.Context, alias string) error {
+	const op = "storage.sqlite.ConsumeClick"
+
+	alias = s.opts.Alias(alias)
+
+	var left int64
+	err := s.db.QueryRowContext(ctx, "UPDATE url SET clicks_left = clicks_left - 1 WHERE alias_key = ? AND clicks_left > 0 RETURNING clicks_left", alias).Scan(&left)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.missingOr(ctx, alias, storage.ErrURLExhausted)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListURLs returns one page of links matching q.
+func (s *Storage) ListURLs(ctx context.Context, q storage.ListQuery) ([]storage.URL, error) {
+	const op = "storage.sqlite.ListURLs"
+
+	var where []string
+	var args []any
+
+	q.AliasPrefix = s.opts.Alias(q.AliasPrefix)
+
+	if q.AliasPrefix != "" {
+		// A range scan can use the alias key index, unlike LIKE, which is case-insensitive in SQLite.
+		where = append(where, "alias_key >= ? AND alias_key < ?")
+		args = append(args, q.AliasPrefix, q.AliasPrefix+"\U0010FFFF")
+	}
+	if q.URLContains != "" {
+		where = append(where, "instr(lower(url), lower(?)) > 0")
+		args = append(args, q.URLContains)
+	}
+	if q.Domain != "" {
+		where = append(where, "domain = ?")
+		args = append(args, strings.ToLower(q.Domain))
+	}
+
+	cmp, dir := ">", "ASC"
+	if q.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	var orderBy string
+	switch q.SortBy {
+	case storage.SortByAlias:
+		orderBy = "alias " + dir
+		if q.After != nil {
+			where = append(where, "alias "+cmp+" ?")
+			args = append(args, q.After.Alias)
+		}
+	default:
+		orderBy = "created_at " + dir + ", id " + dir
+		if q.After != nil {
+			where = append(where, "(created_at, id) "+cmp+" (?, ?)")
+			args = append(args, q.After.CreatedAt.Unix(), q.After.ID)
+		}
+	}
+
+	query := "SELECT " + urlColumns + " FROM url"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + orderBy + " LIMIT ?"
+	args = append(args, q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var urls []storage.URL
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		urls = append(urls, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return urls, nil
+}
+
+// urlColumns are the columns scanURL reads, in order.
+const urlColumns = "id, alias, url, created_at, updated_at, version, expires_at, created_by, redirect_status, passthrough, utm, utm_template, password_hash, max_clicks, clicks_left, not_before, fallback_url, targets, country_targets"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanURL(row scanner) (storage.URL, error) {
+	var u storage.URL
+	var createdAt int64
+	var updatedAt, expiresAt, notBefore sql.NullInt64
+	var utm, targets, countryTargets string
+
+	if err := row.Scan(&u.ID, &u.Alias, &u.URL, &createdAt, &updatedAt, &u.Version, &expiresAt, &u.CreatedBy, &u.RedirectStatus, &u.Passthrough, &utm, &u.UTMTemplate, &u.PasswordHash, &u.MaxClicks, &u.ClicksLeft, &notBefore, &u.FallbackURL, &targets, &countryTargets); err != nil {
+		return u, err
+	}
+
+	u.UTM = storage.ParseUTM(utm)
+	u.Targets = storage.ParseTargets(targets)
+	u.CountryTargets = storage.ParseCountryTargets(countryTargets)
+	u.CreatedAt = time.Unix(createdAt, 0).UTC()
+	u.UpdatedAt = fromUnix(updatedAt)
+	u.ExpiresAt = fromUnix(expiresAt)
+	u.NotBefore = fromUnix(notBefore)
+
+	return u, nil
+}
+
+// NextAliasNumber returns a number for a generated alias. Numbers are never
+// handed out twice, also not to other instances sharing the database.
+func (s *Storage) NextAliasNumber(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.NextAliasNumber"
+
+	var n int64
+	if err := s.db.QueryRowContext(ctx, "UPDATE alias_number SET next = next + 1 RETURNING next - 1").Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// SaveUTMTemplate creates the template t or replaces the one of that name.
+func (s *Storage) SaveUTMTemplate(ctx context.Context, t storage.UTMTemplate) error {
+	const op = "storage.sqlite.SaveUTMTemplate"
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO utm_template (name, utm) VALUES (?, ?)
+	ON CONFLICT (name) DO UPDATE SET utm = excluded.utm`,
+		t.Name, t.UTM.Encode(),
+	)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	res, err := stmt.Exec(alias)
+	return nil
+}
+
+func (s *Storage) GetUTMTemplate(ctx context.Context, name string) (storage.UTMTemplate, error) {
+	const op = "storage.sqlite.GetUTMTemplate"
+
+	var utm string
+	err := s.db.QueryRowContext(ctx, "SELECT utm FROM utm_template WHERE name = ?", name).Scan(&utm)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.UTMTemplate{}, storage.ErrUTMTemplateNotFound
+	}
+	if err != nil {
+		return storage.UTMTemplate{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return storage.UTMTemplate{Name: name, UTM: storage.ParseUTM(utm)}, nil
+}
+
+// ListUTMTemplates returns all templates ordered by name.
+func (s *Storage) ListUTMTemplates(ctx context.Context) ([]storage.UTMTemplate, error) {
+	const op = "storage.sqlite.ListUTMTemplates"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name, utm FROM utm_template ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var templates []storage.UTMTemplate
+	for rows.Next() {
+		var t storage.UTMTemplate
+		var utm string
+		if err := rows.Scan(&t.Name, &utm); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		t.UTM = storage.ParseUTM(utm)
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return templates, nil
+}
+
+// DeleteUTMTemplate removes the named template. Links using it keep their
+// own UTM parameters.
+func (s *Storage) DeleteUTMTemplate(ctx context.Context, name string) error {
+	const op = "storage.sqlite.DeleteUTMTemplate"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM utm_template WHERE name = ?", name)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -106,8 +566,189 @@ func (s *Storage) DeleteURL(alias string) error {
 	}
 
 	if rowsAffected == 0 {
-		return storage.ErrURLNotFound
+		return storage.ErrUTMTemplateNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpiredURLs removes all links that expired at or before before,
+// and their clicks.
+func (s *Storage) DeleteExpiredURLs(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredURLs"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteLinks(ctx, tx, "expires_at <= ?", before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int64(len(deleted)), nil
+}
+
+// SaveClicks stores a batch of click events in a single transaction. Clicks
+// on links deleted meanwhile are dropped, so that a new link under the same
+// alias starts without them.
+func (s *Storage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	const op = "storage.sqlite.SaveClicks"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO click (alias, clicked_at, referrer, user_agent, ip_hash) SELECT alias, ?, ?, ?, ? FROM url WHERE alias_key = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		_, err := stmt.ExecContext(ctx, c.ClickedAt.Unix(), c.Referrer, c.UserAgent, c.IPHash, s.opts.Alias(c.Alias))
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// ClickStats aggregates the clicks on alias selected by q.
+func (s *Storage) ClickStats(ctx context.Context, alias string, q storage.StatsQuery) (storage.ClickStats, error) {
+	const op = "storage.sqlite.ClickStats"
+
+	alias = s.opts.Alias(alias)
+
+	var stats storage.ClickStats
+
+	// Clicks are recorded under the alias as stored.
+	err := s.db.QueryRowContext(ctx, "SELECT alias FROM url WHERE alias_key = ?", alias).Scan(&alias)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stats, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return stats, fmt.Errorf("%s: %w", op, err)
+	}
+
+	from, to := q.From.Unix(), q.To.Unix()
+
+	err = s.db.QueryRowContext(ctx, `
+	SELECT COUNT(*), COUNT(DISTINCT NULLIF(ip_hash, ''))
+	FROM click WHERE alias = ? AND clicked_at >= ? AND clicked_at < ?`,
+		alias, from, to,
+	).Scan(&stats.Total, &stats.Unique)
+	if err != nil {
+		return stats, fmt.Errorf("%s: totals: %w", op, err)
+	}
+
+	width, offset := bucketWidth(q.Bucket)
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT (clicked_at - ?2) / ?1 * ?1 + ?2 AS bucket, COUNT(*)
+	FROM click WHERE alias = ?3 AND clicked_at >= ?4 AND clicked_at < ?5
+	GROUP BY bucket ORDER BY bucket`,
+		width, offset, alias, from, to,
+	)
+	if err != nil {
+		return stats, fmt.Errorf("%s: series: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var start int64
+		var bc storage.BucketCount
+		if err := rows.Scan(&start, &bc.Clicks); err != nil {
+			return stats, fmt.Errorf("%s: series: %w", op, err)
+		}
+		bc.Start = time.Unix(start, 0).UTC()
+		stats.Series = append(stats.Series, bc)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("%s: series: %w", op, err)
+	}
+
+	stats.TopReferrers, err = s.topClickValues(ctx, "referrer", alias, from, to, q.Top)
+	if err != nil {
+		return stats, fmt.Errorf("%s: referrers: %w", op, err)
+	}
+
+	stats.TopUserAgents, err = s.topClickValues(ctx, "user_agent", alias, from, to, q.Top)
+	if err != nil {
+		return stats, fmt.Errorf("%s: user agents: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// topClickValues ranks the non-empty values of a click column by frequency.
+// column is always one of our own column names, never user input.
+func (s *Storage) topClickValues(ctx context.Context, column, alias string, from, to int64, limit int) ([]storage.ValueCount, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+column+`, COUNT(*) AS clicks
+	FROM click WHERE alias = ? AND clicked_at >= ? AND clicked_at < ? AND `+column+` != ''
+	GROUP BY `+column+` ORDER BY clicks DESC, `+column+` LIMIT ?`,
+		alias, from, to, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []storage.ValueCount
+	for rows.Next() {
+		var vc storage.ValueCount
+		if err := rows.Scan(&vc.Value, &vc.Clicks); err != nil {
+			return nil, err
+		}
+		values = append(values, vc)
+	}
+
+	return values, rows.Err()
+}
+
+// bucketWidth returns the bucket size in seconds and the offset that aligns
+// buckets to storage.Bucket.Truncate (weeks start on Monday, 1970-01-05).
+func bucketWidth(b storage.Bucket) (width, offset int64) {
+	switch b {
+	case storage.BucketHour:
+		return 3600, 0
+	case storage.BucketWeek:
+		return 7 * 86400, 4 * 86400
+	default:
+		return 86400, 0
+	}
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// toUnix converts an optional time to the nullable INTEGER the schema stores.
+func toUnix(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+func fromUnix(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.Unix(v.Int64, 0).UTC()
+	return &t
+}
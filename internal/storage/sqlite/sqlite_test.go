@@ -0,0 +1,720 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"urlShortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	s, err := New(filepath.Join(t.TempDir(), "storage.db"), storage.Options{})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestStorage_SaveGetDelete(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	id, err := s.SaveURL(ctx, "https://google.com", "google", storage.URLOptions{})
+	require.NoError(t, err)
+	assert.Positive(t, id)
+
+	_, err = s.SaveURL(ctx, "https://example.com", "google", storage.URLOptions{})
+	assert.ErrorIs(t, err, storage.ErrURLExists)
+
+	got, err := s.GetURL(ctx, "google")
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", got.URL)
+
+	require.NoError(t, s.DeleteURL(ctx, "google"))
+
+	_, err = s.GetURL(ctx, "google")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	err = s.DeleteURL(ctx, "google")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_Expiry(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	_, err := s.SaveURL(ctx, "https://expired.com", "expired", storage.URLOptions{ExpiresAt: &past})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://active.com", "active", storage.URLOptions{ExpiresAt: &future})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://forever.com", "forever", storage.URLOptions{})
+	require.NoError(t, err)
+
+	_, err = s.GetURL(ctx, "expired")
+	assert.ErrorIs(t, err, storage.ErrURLExpired)
+
+	got, err := s.GetURL(ctx, "active")
+	require.NoError(t, err)
+	assert.Equal(t, "https://active.com", got.URL)
+
+	// Still within the grace period.
+	deleted, err := s.DeleteExpiredURLs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	deleted, err = s.DeleteExpiredURLs(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = s.GetURL(ctx, "expired")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	_, err = s.GetURL(ctx, "forever")
+	assert.NoError(t, err)
+}
+
+func TestStorage_SaveClicks(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	_, err := s.SaveURL(ctx, "https://google.com", "google", storage.URLOptions{})
+	require.NoError(t, err)
+
+	now := time.Now()
+	clicks := []storage.Click{
+		{Alias: "google", ClickedAt: now, Referrer: "https://example.com", UserAgent: "agent", IPHash: "a"},
+		{Alias: "google", ClickedAt: now, IPHash: "b"},
+		// Links can be deleted between a click and its flush.
+		{Alias: "deleted", ClickedAt: now, IPHash: "c"},
+	}
+
+	require.NoError(t, s.SaveClicks(ctx, clicks))
+
+	var count int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM click WHERE alias = ?", "google").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	err = s.db.QueryRow("SELECT COUNT(*) FROM click WHERE alias = ?", "deleted").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestStorage_DeleteRemovesClicks(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	past := time.Now().Add(-time.Hour)
+	for _, alias := range []string{"single", "batch", "expired"} {
+		opts := storage.URLOptions{}
+		if alias == "expired" {
+			opts.ExpiresAt = &past
+		}
+		_, err := s.SaveURL(ctx, "https://google.com", alias, opts)
+		require.NoError(t, err)
+		require.NoError(t, s.SaveClicks(ctx, []storage.Click{{Alias: alias, ClickedAt: time.Now(), IPHash: "a"}}))
+	}
+
+	require.NoError(t, s.DeleteURL(ctx, "single"))
+	_, err := s.DeleteURLs(ctx, storage.DeleteQuery{Aliases: []string{"batch"}})
+	require.NoError(t, err)
+	_, err = s.DeleteExpiredURLs(ctx, time.Now())
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, s.db.QueryRow("SELECT COUNT(*) FROM click").Scan(&count))
+	assert.Equal(t, 0, count)
+
+	// A new link under a deleted alias starts without history.
+	_, err = s.SaveURL(ctx, "https://example.com", "single", storage.URLOptions{})
+	require.NoError(t, err)
+
+	stats, err := s.ClickStats(ctx, "single", storage.StatsQuery{From: past, To: time.Now().Add(time.Hour), Bucket: storage.BucketDay})
+	require.NoError(t, err)
+	assert.Zero(t, stats.Total)
+}
+
+func TestStorage_ClickStats(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	_, err := s.SaveURL(ctx, "https://google.com", "google", storage.URLOptions{})
+	require.NoError(t, err)
+
+	// Monday 2026-01-05 and the following days.
+	base := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	clicks := []storage.Click{
+		{Alias: "google", ClickedAt: base, Referrer: "https://a.com", UserAgent: "curl", IPHash: "1"},
+		{Alias: "google", ClickedAt: base.Add(30 * time.Minute), Referrer: "https://a.com", UserAgent: "firefox", IPHash: "1"},
+		{Alias: "google", ClickedAt: base.Add(26 * time.Hour), Referrer: "https://b.com", UserAgent: "curl", IPHash: "2"},
+		{Alias: "google", ClickedAt: base.Add(7 * 24 * time.Hour), UserAgent: "curl", IPHash: "3"},
+		{Alias: "other", ClickedAt: base, IPHash: "4"},
+	}
+	require.NoError(t, s.SaveClicks(ctx, clicks))
+
+	q := storage.StatsQuery{
+		From:   base.Add(-time.Hour),
+		To:     base.Add(7 * 24 * time.Hour),
+		Bucket: storage.BucketDay,
+		Top:    10,
+	}
+
+	stats, err := s.ClickStats(ctx, "google", q)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, int64(2), stats.Unique)
+	assert.Equal(t, []storage.BucketCount{
+		{Start: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC), Clicks: 2},
+		{Start: time.Date(2026, 1, 6, 0, 0, 0, 0, time.UTC), Clicks: 1},
+	}, stats.Series)
+	assert.Equal(t, []storage.ValueCount{
+		{Value: "https://a.com", Clicks: 2},
+		{Value: "https://b.com", Clicks: 1},
+	}, stats.TopReferrers)
+	assert.Equal(t, []storage.ValueCount{
+		{Value: "curl", Clicks: 2},
+		{Value: "firefox", Clicks: 1},
+	}, stats.TopUserAgents)
+
+	q.Bucket = storage.BucketWeek
+	q.To = base.Add(8 * 24 * time.Hour)
+	q.Top = 1
+
+	stats, err = s.ClickStats(ctx, "google", q)
+	require.NoError(t, err)
+
+	assert.Equal(t, []storage.BucketCount{
+		{Start: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC), Clicks: 3},
+		{Start: time.Date(2026, 1, 12, 0, 0, 0, 0, time.UTC), Clicks: 1},
+	}, stats.Series)
+	assert.Len(t, stats.TopUserAgents, 1)
+
+	_, err = s.ClickStats(ctx, "missing", q)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_UpdateURL(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	_, err := s.SaveURL(ctx, "https://gogle.com", "google", storage.URLOptions{})
+	require.NoError(t, err)
+
+	version, err := s.UpdateURL(ctx, "google", "https://google.com", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+
+	got, err := s.GetURL(ctx, "google")
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", got.URL)
+
+	// A stale version is rejected and leaves the link untouched.
+	_, err = s.UpdateURL(ctx, "google", "https://example.com", 1)
+	assert.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+	got, err = s.GetURL(ctx, "google")
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", got.URL)
+
+	// Version 0 updates unconditionally.
+	version, err = s.UpdateURL(ctx, "google", "https://example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), version)
+
+	_, err = s.UpdateURL(ctx, "missing", "https://example.com", 0)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	_, err = s.UpdateURL(ctx, "missing", "https://example.com", 1)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_GetURLInfo(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	id, err := s.SaveURL(ctx, "https://gogle.com", "google", storage.URLOptions{CreatedBy: "alice"})
+	require.NoError(t, err)
+
+	u, err := s.GetURLInfo(ctx, "google")
+	require.NoError(t, err)
+	assert.Equal(t, id, u.ID)
+	assert.Equal(t, "https://gogle.com", u.URL)
+	assert.Equal(t, "alice", u.CreatedBy)
+	assert.Equal(t, int64(1), u.Version)
+	assert.Nil(t, u.UpdatedAt)
+
+	_, err = s.UpdateURL(ctx, "google", "https://google.com", 0)
+	require.NoError(t, err)
+
+	u, err = s.GetURLInfo(ctx, "google")
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", u.URL)
+	require.NotNil(t, u.UpdatedAt)
+	assert.WithinDuration(t, time.Now(), *u.UpdatedAt, time.Minute)
+
+	_, err = s.GetURLInfo(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_SaveURLs(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	_, err := s.SaveURL(ctx, "https://example.com", "taken", storage.URLOptions{})
+	require.NoError(t, err)
+
+	// Best effort saves what it can, including only the first of two equal aliases.
+	results, err := s.SaveURLs(ctx, []storage.NewURL{
+		{URL: "https://google.com", Alias: "google", URLOptions: storage.URLOptions{CreatedBy: "alice"}},
+		{URL: "https://example.com", Alias: "taken"},
+		{URL: "https://github.com", Alias: "google"},
+	}, false)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.Positive(t, results[0].ID)
+	assert.ErrorIs(t, results[1].Err, storage.ErrURLExists)
+	assert.ErrorIs(t, results[2].Err, storage.ErrURLExists)
+
+	u, err := s.GetURLInfo(ctx, "google")
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", u.URL)
+	assert.Equal(t, "alice", u.CreatedBy)
+
+	// Atomic rolls back everything on the first taken alias.
+	_, err = s.SaveURLs(ctx, []storage.NewURL{
+		{URL: "https://github.com", Alias: "github"},
+		{URL: "https://example.com", Alias: "taken"},
+	}, true)
+	var batchErr *storage.BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	assert.ErrorIs(t, err, storage.ErrURLExists)
+
+	_, err = s.GetURL(ctx, "github")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	results, err = s.SaveURLs(ctx, []storage.NewURL{
+		{URL: "https://github.com", Alias: "github"},
+		{URL: "https://go.dev", Alias: "go"},
+	}, true)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.NoError(t, results[1].Err)
+
+	got, err := s.GetURL(ctx, "go")
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", got.URL)
+}
+
+func TestStorage_DeleteURLs(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	for _, l := range []struct{ alias, url string }{
+		{"a", "https://google.com/a"},
+		{"b", "https://google.com/b"},
+		{"c", "https://example.com/c"},
+		{"d", "https://example.com/d"},
+	} {
+		_, err := s.SaveURL(ctx, l.url, l.alias, storage.URLOptions{})
+		require.NoError(t, err)
+	}
+
+	res, err := s.DeleteURLs(ctx, storage.DeleteQuery{Aliases: []string{"a", "missing", "c"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Deleted)
+	assert.Equal(t, []string{"missing"}, res.NotFound)
+
+	_, err = s.GetURL(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	// An empty query deletes nothing.
+	res, err = s.DeleteURLs(ctx, storage.DeleteQuery{})
+	require.NoError(t, err)
+	assert.Zero(t, res.Deleted)
+
+	past := time.Now().Add(-time.Hour)
+	res, err = s.DeleteURLs(ctx, storage.DeleteQuery{CreatedBefore: &past})
+	require.NoError(t, err)
+	assert.Zero(t, res.Deleted)
+
+	res, err = s.DeleteURLs(ctx, storage.DeleteQuery{Domain: "Google.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.Deleted)
+	assert.Empty(t, res.NotFound)
+
+	future := time.Now().Add(time.Hour)
+	res, err = s.DeleteURLs(ctx, storage.DeleteQuery{CreatedBefore: &future})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.Deleted)
+
+	_, err = s.GetURL(ctx, "d")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_NextAliasNumber(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	s, err := New(path, storage.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	n, err := s.NextAliasNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	n, err = s.NextAliasNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// Another instance on the same database carries on from there.
+	other, err := New(path, storage.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { other.Close() })
+
+	n, err = other.NextAliasNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
+
+func TestStorage_GetURLByDestination(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	_, err := s.SaveURL(ctx, "https://google.com/", "expiring", storage.URLOptions{ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "protected", storage.URLOptions{PasswordHash: "$2a$10$hash"})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "limited", storage.URLOptions{MaxClicks: 1})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "scheduled", storage.URLOptions{NotBefore: &expiresAt})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "targeted", storage.URLOptions{
+		Targets: []storage.Target{{Platform: storage.TargetAndroid, URL: "https://play.google.com"}},
+	})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "regional", storage.URLOptions{
+		CountryTargets: []storage.CountryTarget{{Countries: []string{"DE"}, URL: "https://google.de"}},
+	})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "permanent", storage.URLOptions{RedirectStatus: 301})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "passthrough", storage.URLOptions{Passthrough: true})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "tagged", storage.URLOptions{UTM: storage.UTM{Source: "mail"}})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com/", "templated", storage.URLOptions{UTMTemplate: "mail"})
+	require.NoError(t, err)
+
+	_, err = s.GetURLByDestination(ctx, "https://google.com")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	_, err = s.SaveURL(ctx, "https://google.com/", "first", storage.URLOptions{})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://google.com", "second", storage.URLOptions{})
+	require.NoError(t, err)
+
+	u, err := s.GetURLByDestination(ctx, "HTTPS://Google.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, "first", u.Alias)
+
+	_, err = s.GetURLByDestination(ctx, "https://google.com/other")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	// Fragments and userinfo can point at different content.
+	_, err = s.SaveURL(ctx, "https://google.com/page#a", "fragment", storage.URLOptions{})
+	require.NoError(t, err)
+
+	u, err = s.GetURLByDestination(ctx, "https://google.com/page#a")
+	require.NoError(t, err)
+	assert.Equal(t, "fragment", u.Alias)
+
+	_, err = s.GetURLByDestination(ctx, "https://google.com/page#b")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	_, err = s.GetURLByDestination(ctx, "https://google.com/page")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	_, err = s.GetURLByDestination(ctx, "https://user@google.com/page#a")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestStorage_ListURLs(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	for _, l := range []struct{ alias, url string }{
+		{"go-a", "https://google.com/search"},
+		{"go-b", "https://GOOGLE.com:443/maps"},
+		{"gh", "https://github.com/golang/go"},
+		{"ex", "https://example.com/Search?q=1"},
+	} {
+		_, err := s.SaveURL(ctx, l.url, l.alias, storage.URLOptions{})
+		require.NoError(t, err)
+	}
+
+	aliases := func(urls []storage.URL) []string {
+		var out []string
+		for _, u := range urls {
+			out = append(out, u.Alias)
+		}
+		return out
+	}
+
+	urls, err := s.ListURLs(ctx, storage.ListQuery{SortBy: storage.SortByAlias, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ex", "gh", "go-a", "go-b"}, aliases(urls))
+	assert.Equal(t, "https://example.com/Search?q=1", urls[0].URL)
+	assert.WithinDuration(t, time.Now(), urls[0].CreatedAt, time.Minute)
+
+	urls, err = s.ListURLs(ctx, storage.ListQuery{AliasPrefix: "go", SortBy: storage.SortByAlias, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go-a", "go-b"}, aliases(urls))
+
+	urls, err = s.ListURLs(ctx, storage.ListQuery{URLContains: "search", SortBy: storage.SortByAlias, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ex", "go-a"}, aliases(urls))
+
+	urls, err = s.ListURLs(ctx, storage.ListQuery{Domain: "Google.com", SortBy: storage.SortByAlias, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go-a", "go-b"}, aliases(urls))
+
+	// Links saved within the same second are ordered by id.
+	var pages []string
+	q := storage.ListQuery{SortBy: storage.SortByCreatedAt, Desc: true, Limit: 3}
+	for {
+		urls, err := s.ListURLs(ctx, q)
+		require.NoError(t, err)
+		pages = append(pages, aliases(urls)...)
+		if len(urls) < q.Limit {
+			break
+		}
+		last := urls[len(urls)-1]
+		q.After = &storage.ListCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	assert.Equal(t, []string{"ex", "gh", "go-b", "go-a"}, pages)
+
+	q = storage.ListQuery{SortBy: storage.SortByAlias, Desc: true, After: &storage.ListCursor{Alias: "go-a"}, Limit: 10}
+	urls, err = s.ListURLs(ctx, q)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gh", "ex"}, aliases(urls))
+}
+
+func TestStorage_CaseInsensitiveAliases(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	s, err := New(path, storage.Options{})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://example.com", "MyLink", storage.URLOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.SaveClicks(ctx, []storage.Click{{Alias: "MyLink", ClickedAt: time.Now()}}))
+	require.NoError(t, s.Close())
+
+	s, err = New(path, storage.Options{CaseInsensitiveAliases: true})
+	require.NoError(t, err)
+
+	got, err := s.GetURL(ctx, "MYLINK")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", got.URL)
+
+	// The alias is kept as it was given.
+	info, err := s.GetURLInfo(ctx, "mylink")
+	require.NoError(t, err)
+	assert.Equal(t, "MyLink", info.Alias)
+
+	require.NoError(t, s.SaveClicks(ctx, []storage.Click{{Alias: "mylink", ClickedAt: time.Now()}}))
+
+	stats, err := s.ClickStats(ctx, "MYLINK", storage.StatsQuery{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour), Top: 5})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stats.Total)
+
+	_, err = s.SaveURL(ctx, "https://example.org", "myLINK", storage.URLOptions{})
+	assert.ErrorIs(t, err, storage.ErrURLExists)
+
+	res, err := s.SaveURLs(ctx, []storage.NewURL{{URL: "https://example.org", Alias: "MYLINK"}}, false)
+	require.NoError(t, err)
+	assert.ErrorIs(t, res[0].Err, storage.ErrURLExists)
+
+	// Aliases that are not found are reported as they were given.
+	deleted, err := s.DeleteURLs(ctx, storage.DeleteQuery{Aliases: []string{"Gone"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Gone"}, deleted.NotFound)
+
+	_, err = s.SaveURL(ctx, "https://example.org", "Other", storage.URLOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// Turning the setting off again restores exact matching.
+	s, err = New(path, storage.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	_, err = s.GetURL(ctx, "mylink")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	_, err = s.GetURL(ctx, "Other")
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteURL(ctx, "MyLink"))
+	assert.ErrorIs(t, s.DeleteURL(ctx, "MyLink"), storage.ErrURLNotFound)
+}
+
+func TestStorage_CaseInsensitiveAliasesConflict(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	s, err := New(path, storage.Options{})
+	require.NoError(t, err)
+	for _, alias := range []string{"Dup", "dup", "other"} {
+		_, err = s.SaveURL(ctx, "https://example.com", alias, storage.URLOptions{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+
+	_, err = New(path, storage.Options{CaseInsensitiveAliases: true})
+	require.ErrorIs(t, err, storage.ErrAliasConflict)
+	assert.Contains(t, err.Error(), "dup")
+
+	// Nothing was rewritten, so the case-sensitive mode still works.
+	s, err = New(path, storage.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	got, err := s.GetURLInfo(ctx, "Dup")
+	require.NoError(t, err)
+	assert.Equal(t, "Dup", got.Alias)
+}
+
+func TestStorage_RedirectOptions(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	notBefore := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	opts := storage.URLOptions{
+		RedirectStatus: 308,
+		Passthrough:    true,
+		UTM:            storage.UTM{Source: "news letter", Campaign: "q&a"},
+		UTMTemplate:    "mail",
+		PasswordHash:   "$2a$10$hash",
+		NotBefore:      &notBefore,
+		FallbackURL:    "https://example.com/soon",
+		Targets: []storage.Target{
+			{Platform: storage.TargetIOS, URL: "https://apps.apple.com/app/id1"},
+			{Platform: storage.TargetRegex, Pattern: "(?i)kindle", URL: "https://example.com/kindle"},
+		},
+		CountryTargets: []storage.CountryTarget{{Countries: []string{"DE", "AT"}, URL: "https://example.de"}},
+	}
+	_, err := s.SaveURL(ctx, "https://example.com", "single", opts)
+	require.NoError(t, err)
+
+	_, err = s.SaveURLs(ctx, []storage.NewURL{{URL: "https://example.org", Alias: "batched", URLOptions: opts}}, true)
+	require.NoError(t, err)
+
+	for _, alias := range []string{"single", "batched"} {
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		assert.Equal(t, opts, got.URLOptions, alias)
+	}
+}
+
+func TestStorage_UTMTemplates(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	_, err := s.GetUTMTemplate(ctx, "mail")
+	assert.ErrorIs(t, err, storage.ErrUTMTemplateNotFound)
+
+	require.NoError(t, s.SaveUTMTemplate(ctx, storage.UTMTemplate{Name: "mail", UTM: storage.UTM{Source: "newsletter"}}))
+	require.NoError(t, s.SaveUTMTemplate(ctx, storage.UTMTemplate{Name: "ads", UTM: storage.UTM{Source: "google", Medium: "cpc"}}))
+
+	// Saving under an existing name replaces the template.
+	require.NoError(t, s.SaveUTMTemplate(ctx, storage.UTMTemplate{Name: "mail", UTM: storage.UTM{Source: "weekly", Medium: "email"}}))
+
+	got, err := s.GetUTMTemplate(ctx, "mail")
+	require.NoError(t, err)
+	assert.Equal(t, storage.UTM{Source: "weekly", Medium: "email"}, got.UTM)
+
+	templates, err := s.ListUTMTemplates(ctx)
+	require.NoError(t, err)
+	require.Len(t, templates, 2)
+	assert.Equal(t, "ads", templates[0].Name)
+	assert.Equal(t, "mail", templates[1].Name)
+
+	require.NoError(t, s.DeleteUTMTemplate(ctx, "ads"))
+	assert.ErrorIs(t, s.DeleteUTMTemplate(ctx, "ads"), storage.ErrUTMTemplateNotFound)
+}
+
+func TestStorage_MaxClicks(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t)
+
+	_, err := s.SaveURL(ctx, "https://example.com/invite", "invite", storage.URLOptions{MaxClicks: 3})
+	require.NoError(t, err)
+	_, err = s.SaveURL(ctx, "https://example.com", "unlimited", storage.URLOptions{})
+	require.NoError(t, err)
+
+	u, err := s.GetURL(ctx, "invite")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), u.MaxClicks)
+	assert.Equal(t, int64(3), u.ClicksLeft)
+
+	// Concurrent visits never take more clicks than the link has.
+	var wg sync.WaitGroup
+	var used atomic.Int64
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.ConsumeClick(ctx, "invite")
+			if err == nil {
+				used.Add(1)
+				return
+			}
+			assert.ErrorIs(t, err, storage.ErrURLExhausted)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(3), used.Load())
+
+	_, err = s.GetURL(ctx, "invite")
+	assert.ErrorIs(t, err, storage.ErrURLExhausted)
+
+	u, err = s.GetURLInfo(ctx, "invite")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), u.ClicksLeft)
+
+	assert.ErrorIs(t, s.ConsumeClick(ctx, "unlimited"), storage.ErrURLExhausted)
+	assert.ErrorIs(t, s.ConsumeClick(ctx, "missing"), storage.ErrURLNotFound)
+}
@@ -1,8 +1,468 @@
 package storage
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
 
 var (
-	ErrURLNotFound      = errors.New("url not found")
-	ErrURLExists = errors.New("url exists")
+	ErrURLNotFound = errors.New("url not found")
+	ErrURLExists   = errors.New("url exists")
+	ErrURLExpired  = errors.New("url expired")
+	// ErrURLExhausted is returned for links whose click limit is used up.
+	ErrURLExhausted = errors.New("url click limit reached")
+	// ErrVersionMismatch is returned when a conditional update finds the link
+	// at a different version than the caller expected.
+	ErrVersionMismatch     = errors.New("url version mismatch")
+	ErrUTMTemplateNotFound = errors.New("utm template not found")
+	// ErrAliasConflict is returned when case-insensitive aliases are turned
+	// on for a storage holding aliases that differ only in case.
+	ErrAliasConflict = errors.New("aliases differ only in case")
+)
+
+// Options holds the settings a storage backend is opened with.
+type Options struct {
+	// CaseInsensitiveAliases looks aliases up in canonical form, so
+	// "MyLink" and "mylink" name the same link. Aliases are stored as given.
+	CaseInsensitiveAliases bool
+}
+
+// Alias returns the key the storage looks alias up by under o.
+func (o Options) Alias(alias string) string {
+	if o.CaseInsensitiveAliases {
+		return CanonicalAlias(alias)
+	}
+	return alias
+}
+
+// Aliases is Alias applied to every element of aliases.
+func (o Options) Aliases(aliases []string) []string {
+	if !o.CaseInsensitiveAliases {
+		return aliases
+	}
+
+	canonical := make([]string, len(aliases))
+	for i, alias := range aliases {
+		canonical[i] = CanonicalAlias(alias)
+	}
+	return canonical
+}
+
+// CanonicalAlias lowercases the ASCII letters of alias. Other characters
+// are kept as they are, the same as SQLite's lower() does.
+func CanonicalAlias(alias string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, alias)
+}
+
+// URLOptions holds the optional settings stored alongside a link.
+type URLOptions struct {
+	// ExpiresAt is the moment the link stops resolving. Nil means never.
+	ExpiresAt *time.Time
+	// CreatedBy names the API user who created the link, if known.
+	CreatedBy string
+	// RedirectStatus is the HTTP status the link redirects with, one of
+	// 301, 302, 307 or 308. Zero means the server default.
+	RedirectStatus int
+	// Passthrough forwards the query string and any path below the alias
+	// of a visit to the destination.
+	Passthrough bool
+	// UTM is appended to the destination at redirect time, on top of the
+	// named UTMTemplate, if any.
+	UTM         UTM
+	UTMTemplate string
+	// PasswordHash is the bcrypt hash visitors must match before being
+	// redirected. Empty means the link is public.
+	PasswordHash string
+	// MaxClicks is how many times the link can be followed. Zero means
+	// no limit.
+	MaxClicks int64
+	// NotBefore is the moment the link starts resolving. Nil means at once.
+	NotBefore *time.Time
+	// FallbackURL is where visits before NotBefore go, if anywhere.
+	FallbackURL string
+	// Targets send visits from matching clients elsewhere than the link's
+	// URL. The first match wins.
+	Targets []Target
+	// CountryTargets do the same by the country visits come from. They
+	// are checked after Targets.
+	CountryTargets []CountryTarget
+}
+
+// Target platforms. TargetRegex matches the target's Pattern against the
+// User-Agent header instead.
+const (
+	TargetIOS     = "ios"
+	TargetAndroid = "android"
+	TargetDesktop = "desktop"
+	TargetRegex   = "regex"
 )
+
+// Target is an alternative destination for visits from one platform.
+type Target struct {
+	Platform string `json:"platform"`
+	Pattern  string `json:"pattern,omitempty"`
+	URL      string `json:"url"`
+}
+
+// CountryTarget is an alternative destination for visits from any of
+// Countries, given as ISO 3166-1 alpha-2 codes.
+type CountryTarget struct {
+	Countries []string `json:"countries"`
+	URL       string   `json:"url"`
+}
+
+// EncodeTargets returns targets in the form they are stored in, "" for none.
+func EncodeTargets(targets []Target) string {
+	return encodeList(targets)
+}
+
+// ParseTargets reverses EncodeTargets. Malformed input yields no targets.
+func ParseTargets(raw string) []Target {
+	return parseList[Target](raw)
+}
+
+// EncodeCountryTargets is EncodeTargets for country targets.
+func EncodeCountryTargets(targets []CountryTarget) string {
+	return encodeList(targets)
+}
+
+// ParseCountryTargets reverses EncodeCountryTargets.
+func ParseCountryTargets(raw string) []CountryTarget {
+	return parseList[CountryTarget](raw)
+}
+
+func encodeList[T any](list []T) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	raw, _ := json.Marshal(list)
+	return string(raw)
+}
+
+func parseList[T any](raw string) []T {
+	if raw == "" {
+		return nil
+	}
+
+	var list []T
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil
+	}
+
+	return list
+}
+
+// UTM holds the campaign parameters of a link or template.
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Term     string
+	Content  string
+}
+
+// UTMTemplate is a named, reusable set of UTM parameters.
+type UTMTemplate struct {
+	Name string
+	UTM
+}
+
+// Empty reports whether u sets no parameter.
+func (u UTM) Empty() bool {
+	return u == UTM{}
+}
+
+// Merge returns u with the parameters set in over replacing its own.
+func (u UTM) Merge(over UTM) UTM {
+	if over.Source != "" {
+		u.Source = over.Source
+	}
+	if over.Medium != "" {
+		u.Medium = over.Medium
+	}
+	if over.Campaign != "" {
+		u.Campaign = over.Campaign
+	}
+	if over.Term != "" {
+		u.Term = over.Term
+	}
+	if over.Content != "" {
+		u.Content = over.Content
+	}
+
+	return u
+}
+
+// Values returns the query parameters for the fields of u that are set.
+func (u UTM) Values() url.Values {
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"utm_source":   u.Source,
+		"utm_medium":   u.Medium,
+		"utm_campaign": u.Campaign,
+		"utm_term":     u.Term,
+		"utm_content":  u.Content,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+
+	return values
+}
+
+// Encode returns u as the query string the storage keeps it in.
+func (u UTM) Encode() string {
+	return u.Values().Encode()
+}
+
+// ParseUTM reverses UTM.Encode. Unknown or malformed parameters are ignored.
+func ParseUTM(raw string) UTM {
+	values, _ := url.ParseQuery(raw)
+
+	return UTM{
+		Source:   values.Get("utm_source"),
+		Medium:   values.Get("utm_medium"),
+		Campaign: values.Get("utm_campaign"),
+		Term:     values.Get("utm_term"),
+		Content:  values.Get("utm_content"),
+	}
+}
+
+// NewURL is a link to be created by SaveURLs.
+type NewURL struct {
+	URL   string
+	Alias string
+	URLOptions
+}
+
+// SaveResult is the outcome of one link in SaveURLs. Err is ErrURLExists
+// when the alias is taken, including by an earlier link of the same batch.
+type SaveResult struct {
+	ID  int64
+	Err error
+}
+
+// BatchError reports the link that made an atomic batch roll back.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch item %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Click is a single recorded visit of a short link.
+type Click struct {
+	Alias     string
+	ClickedAt time.Time
+	Referrer  string
+	UserAgent string
+	// IPHash is a keyed hash of the client IP; raw addresses are never stored.
+	IPHash string
+}
+
+// Bucket is the width of one step in a click time series.
+type Bucket string
+
+const (
+	BucketHour Bucket = "hour"
+	BucketDay  Bucket = "day"
+	BucketWeek Bucket = "week"
+)
+
+// Truncate returns the start of the UTC bucket containing t. Weeks start on Monday.
+func (b Bucket) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch b {
+	case BucketHour:
+		return t.Truncate(time.Hour)
+	case BucketWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	default:
+		return day
+	}
+}
+
+// StatsQuery selects the clicks that ClickStats aggregates.
+type StatsQuery struct {
+	// From and To bound the clicks to [From, To).
+	From   time.Time
+	To     time.Time
+	Bucket Bucket
+	// Top limits the referrer and user agent rankings.
+	Top int
+}
+
+type ClickStats struct {
+	Total         int64
+	Unique        int64
+	Series        []BucketCount
+	TopReferrers  []ValueCount
+	TopUserAgents []ValueCount
+}
+
+type BucketCount struct {
+	Start  time.Time
+	Clicks int64
+}
+
+type ValueCount struct {
+	Value  string
+	Clicks int64
+}
+
+// URL is a stored link as returned by lookups and listings.
+type URL struct {
+	ID        int64
+	Alias     string
+	URL       string
+	CreatedAt time.Time
+	// UpdatedAt is the time of the last destination change. Nil if never changed.
+	UpdatedAt *time.Time
+	Version   int64
+	// ClicksLeft is how many more visits a link with MaxClicks allows.
+	ClicksLeft int64
+	URLOptions
+}
+
+// Pending reports whether u is not active yet at now.
+func (u URL) Pending(now time.Time) bool {
+	return u.NotBefore != nil && now.Before(*u.NotBefore)
+}
+
+// Exhausted reports whether u had a click limit and used it up.
+func (u URL) Exhausted() bool {
+	return u.MaxClicks > 0 && u.ClicksLeft <= 0
+}
+
+// SortField is the key links are ordered by in a listing.
+type SortField string
+
+const (
+	SortByCreatedAt SortField = "created_at"
+	SortByAlias     SortField = "alias"
+)
+
+// ListQuery selects one page of links for ListURLs.
+type ListQuery struct {
+	// AliasPrefix keeps links whose alias starts with it.
+	AliasPrefix string
+	// URLContains keeps links whose destination contains it, ignoring case.
+	URLContains string
+	// Domain keeps links whose destination host equals it, ignoring case.
+	Domain string
+
+	SortBy SortField
+	Desc   bool
+	// After continues a listing after the given link in SortBy order.
+	After *ListCursor
+	Limit int
+}
+
+// ListCursor is the position of a link in a listing. Only the fields of the
+// listing's sort key are used: CreatedAt and ID, or Alias.
+type ListCursor struct {
+	CreatedAt time.Time
+	ID        int64
+	Alias     string
+}
+
+// DeleteQuery selects the links DeleteURLs removes. Set fields are combined
+// with AND; a query with none set matches nothing.
+type DeleteQuery struct {
+	Aliases []string
+	// CreatedBefore keeps links created strictly before it.
+	CreatedBefore *time.Time
+	// Domain keeps links whose destination host equals it, ignoring case.
+	Domain string
+}
+
+// Empty reports whether q has no conditions and so matches nothing.
+func (q DeleteQuery) Empty() bool {
+	return len(q.Aliases) == 0 && q.CreatedBefore == nil && q.Domain == ""
+}
+
+// Missing returns the aliases of q, in order and without repeats, whose key
+// is not among the deleted keys. They are spelled as in q.
+func (q DeleteQuery) Missing(deleted []string, key func(alias string) string) []string {
+	seen := make(map[string]bool, len(deleted))
+	for _, k := range deleted {
+		seen[k] = true
+	}
+
+	var missing []string
+	for _, alias := range q.Aliases {
+		k := key(alias)
+		if !seen[k] {
+			missing = append(missing, alias)
+			seen[k] = true
+		}
+	}
+
+	return missing
+}
+
+// DeleteResult reports the outcome of DeleteURLs.
+type DeleteResult struct {
+	Deleted int64
+	// NotFound lists the requested aliases that matched no link.
+	NotFound []string
+}
+
+// NormalizeURL returns the form of a destination URL that duplicates are
+// matched by: scheme and host lowercased, default port dropped, and an empty
+// path written as "/". The fragment and userinfo are kept, as they can lead
+// to different content. Unparsable URLs are returned unchanged.
+func NormalizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	u.Host = host
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String()
+}
+
+// DomainOf returns the lowercased host of a destination URL, without port.
+func DomainOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,42 @@
+package sweeper
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"urlShortener/internal/lib/logger/sl"
+)
+
+type ExpiredURLDeleter interface {
+	DeleteExpiredURLs(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Run purges links that expired more than grace ago every interval until
+// ctx is cancelled. Until they are purged, expired links answer 410 Gone;
+// after that, like unknown aliases. interval must be positive.
+func Run(ctx context.Context, log *slog.Logger, deleter ExpiredURLDeleter, interval, grace time.Duration) {
+	const op = "sweeper.Run"
+
+	log = log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := deleter.DeleteExpiredURLs(ctx, time.Now().Add(-grace))
+			if err != nil {
+				log.Error("failed to delete expired urls", sl.Err(err))
+				continue
+			}
+
+			if deleted > 0 {
+				log.Info("expired urls deleted", slog.Int64("count", deleted))
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"urlShortener/internal/lib/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type deleterFunc func(ctx context.Context, before time.Time) (int64, error)
+
+func (f deleterFunc) DeleteExpiredURLs(ctx context.Context, before time.Time) (int64, error) {
+	return f(ctx, before)
+}
+
+func TestRun(t *testing.T) {
+	var calls atomic.Int32
+
+	deleter := deleterFunc(func(ctx context.Context, before time.Time) (int64, error) {
+		// Links stay around for the grace period after they expire.
+		assert.WithinDuration(t, time.Now().Add(-time.Hour), before, time.Second)
+
+		if calls.Add(1) == 1 {
+			return 0, errors.New("db error")
+		}
+		return 1, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, slogdiscard.NewDiscardLogger(), deleter, time.Millisecond, time.Hour)
+		close(done)
+	}()
+
+	// Errors must not stop the sweeper.
+	assert.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+}
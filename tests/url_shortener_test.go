@@ -1,13 +1,22 @@
 package tests
 
 import (
+	"encoding/json"
+	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
+	"time"
 
+	"urlShortener/internal/analytics"
 	"urlShortener/internal/app"
+	"urlShortener/internal/http-server/handlers/redirect"
+	"urlShortener/internal/lib/aliasrule"
+	"urlShortener/internal/lib/clientip"
+	"urlShortener/internal/lib/geoip"
+	"urlShortener/internal/lib/random"
 	"urlShortener/internal/lib/slogdiscard"
-	"urlShortener/internal/storage/sqlite"
+	"urlShortener/internal/storage"
+	"urlShortener/internal/storage/memory"
 
 	"github.com/brianvoe/gofakeit/v6"
 	"github.com/gavv/httpexpect/v2"
@@ -17,29 +26,68 @@ import (
 const (
 	testUser     = "test_user"
 	testPassword = "test_password"
+	testMaxBatch = 10
 )
 
 func setupTestServer(t *testing.T) (*httptest.Server, func()) {
 	t.Helper()
 
-	// Create temp database
-	tempFile, err := os.CreateTemp("", "test_storage_*.db")
+	storage, err := memory.New("", storage.Options{})
 	require.NoError(t, err)
-	tempFile.Close()
 
-	storage, err := sqlite.New(tempFile.Name())
+	log := slogdiscard.NewDiscardLogger()
+
+	// Test clients stand in for a reverse proxy on the loopback address.
+	proxies, err := clientip.NewResolver([]string{"127.0.0.1", "::1"})
 	require.NoError(t, err)
 
-	log := slogdiscard.NewDiscardLogger()
+	clickRecorder := analytics.New(log, storage, analytics.Config{
+		BufferSize:    100,
+		BatchSize:     10,
+		FlushInterval: 10 * time.Millisecond,
+		Proxies:       proxies,
+	})
+
+	validate, err := aliasrule.NewValidator(aliasrule.Rules{
+		MinLength: 3,
+		MaxLength: 64,
+		Charset:   "a-zA-Z0-9_-",
+		Reserved:  app.ReservedAliases,
+	})
+	require.NoError(t, err)
+
+	aliases := random.AliasSource{
+		Generator:   random.NewBase62Generator(6),
+		MaxAttempts: 5,
+		GrowEvery:   2,
+	}
+
+	// The fixture places 81.2.69.0/24 in GB and 89.160.20.0/24 in SE.
+	countries, err := geoip.Open("../internal/lib/geoip/testdata/country.mmdb")
+	require.NoError(t, err)
 
 	// Use the same router configuration as the real application
-	router := app.NewRouter(log, storage, testUser, testPassword)
+	router := app.NewRouter(log, storage, clickRecorder, aliases, validate, app.Config{
+		User:         testUser,
+		Password:     testPassword,
+		MaxBatchSize: testMaxBatch,
+		Redirect: redirect.Config{
+			DefaultStatus:    http.StatusFound,
+			PermanentMaxAge:  time.Hour,
+			PasswordAttempts: 3,
+			PasswordLockout:  time.Minute,
+			PendingStatus:    http.StatusNotFound,
+			PendingMessage:   "not found",
+			Countries:        countries,
+			Proxies:          proxies,
+		},
+	})
 
 	server := httptest.NewServer(router)
 
 	cleanup := func() {
 		server.Close()
-		os.Remove(tempFile.Name())
+		clickRecorder.Close()
 	}
 
 	return server, cleanup
@@ -324,3 +372,899 @@ func TestURLShortener_MultipleURLs(t *testing.T) {
 			Status(404)
 	}
 }
+
+func TestURLShortener_Stats(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	alias := gofakeit.LetterN(10)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{
+			"url":   gofakeit.URL(),
+			"alias": alias,
+		}).
+		Expect().
+		Status(200)
+
+	for i := 0; i < 3; i++ {
+		e.GET("/{alias}", alias).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithHeader("Referer", "https://news.example.com").
+			Expect().
+			Status(302)
+	}
+
+	// Clicks are written asynchronously, so wait for them to show up.
+	require.Eventually(t, func() bool {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/url/"+alias+"/stats", nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(testUser, testPassword)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body struct {
+			TotalClicks int `json:"total_clicks"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		return body.TotalClicks == 3
+	}, time.Second, 20*time.Millisecond)
+
+	stats := e.GET("/url/{alias}/stats", alias).
+		WithBasicAuth(testUser, testPassword).
+		WithQuery("bucket", "hour").
+		Expect().
+		Status(200).
+		JSON().Object()
+
+	stats.HasValue("status", "OK").
+		HasValue("total_clicks", 3).
+		HasValue("unique_clicks", 1).
+		HasValue("bucket", "hour")
+	stats.Value("series").Array().Length().IsEqual(1)
+	stats.Value("top_referrers").Array().Value(0).Object().
+		HasValue("value", "https://news.example.com").
+		HasValue("clicks", 3)
+
+	e.GET("/url/{alias}/stats", "nonexistent").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(404)
+
+	e.GET("/url/{alias}/stats", alias).
+		Expect().
+		Status(401)
+}
+
+func TestURLShortener_Update(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	alias := gofakeit.LetterN(10)
+	newURL := gofakeit.URL()
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{
+			"url":   gofakeit.URL(),
+			"alias": alias,
+		}).
+		Expect().
+		Status(200)
+
+	e.PATCH("/url/{alias}", alias).
+		WithBasicAuth(testUser, testPassword).
+		WithHeader("If-Match", `"1"`).
+		WithJSON(map[string]string{"url": newURL}).
+		Expect().
+		Status(200).
+		Header("ETag").IsEqual(`"2"`)
+
+	e.GET("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(302).
+		Header("Location").IsEqual(newURL)
+
+	// A second writer holding the old ETag loses.
+	e.PATCH("/url/{alias}", alias).
+		WithBasicAuth(testUser, testPassword).
+		WithHeader("If-Match", `"1"`).
+		WithJSON(map[string]string{"url": gofakeit.URL()}).
+		Expect().
+		Status(412).
+		JSON().Object().
+		HasValue("error", "url was modified")
+
+	e.PATCH("/url/{alias}", "nonexistent").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{"url": newURL}).
+		Expect().
+		Status(404)
+
+	e.PATCH("/url/{alias}", alias).
+		WithJSON(map[string]string{"url": newURL}).
+		Expect().
+		Status(401)
+}
+
+func TestURLShortener_List(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	prefix := gofakeit.LetterN(8) + "-"
+	for _, suffix := range []string{"a", "b", "c"} {
+		e.POST("/url").
+			WithBasicAuth(testUser, testPassword).
+			WithJSON(map[string]string{
+				"url":   gofakeit.URL(),
+				"alias": prefix + suffix,
+			}).
+			Expect().
+			Status(200)
+	}
+
+	page := e.GET("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithQuery("alias_prefix", prefix).
+		WithQuery("sort", "alias").
+		WithQuery("limit", 2).
+		Expect().
+		Status(200).
+		JSON().Object()
+
+	page.Value("urls").Array().Length().IsEqual(2)
+	page.Value("urls").Array().Value(0).Object().HasValue("alias", prefix+"a")
+	cursor := page.Value("next_cursor").String().NotEmpty().Raw()
+
+	page = e.GET("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithQuery("alias_prefix", prefix).
+		WithQuery("sort", "alias").
+		WithQuery("limit", 2).
+		WithQuery("cursor", cursor).
+		Expect().
+		Status(200).
+		JSON().Object()
+
+	page.Value("urls").Array().Length().IsEqual(1)
+	page.Value("urls").Array().Value(0).Object().HasValue("alias", prefix+"c")
+	page.NotContainsKey("next_cursor")
+
+	e.GET("/url").
+		Expect().
+		Status(401)
+}
+
+func TestURLShortener_Info(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	url := gofakeit.URL()
+	alias := gofakeit.LetterN(10)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{
+			"url":   url,
+			"alias": alias,
+		}).
+		Expect().
+		Status(200)
+
+	info := e.GET("/url/{alias}", alias).
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200)
+
+	info.Header("ETag").IsEqual(`"1"`)
+
+	obj := info.JSON().Object()
+	obj.HasValue("status", "OK").
+		HasValue("alias", alias).
+		HasValue("url", url).
+		HasValue("created_by", testUser).
+		ContainsKey("id").
+		ContainsKey("created_at").
+		NotContainsKey("updated_at")
+
+	e.PATCH("/url/{alias}", alias).
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{"url": gofakeit.URL()}).
+		Expect().
+		Status(200)
+
+	e.GET("/url/{alias}", alias).
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		ContainsKey("updated_at")
+
+	e.GET("/url/{alias}", "nonexistent").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(404).
+		JSON().Object().
+		HasValue("status", "Error").
+		HasValue("error", "not found")
+
+	e.GET("/url/{alias}", alias).
+		Expect().
+		Status(401)
+}
+
+func TestURLShortener_BatchSave(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	alias := gofakeit.LetterN(10)
+	url := gofakeit.URL()
+
+	res := e.POST("/url/batch").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{
+			"items": []map[string]string{
+				{"url": url, "alias": alias},
+				{"url": gofakeit.URL()},
+				{"url": "not a url"},
+			},
+		}).
+		Expect().
+		Status(http.StatusMultiStatus).
+		JSON().Object()
+
+	items := res.Value("items").Array()
+	items.Length().IsEqual(3)
+	items.Value(0).Object().HasValue("status", "OK").HasValue("alias", alias)
+	items.Value(1).Object().HasValue("status", "OK").Value("alias").String().NotEmpty()
+	items.Value(2).Object().HasValue("status", "Error")
+
+	e.GET("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(302).
+		Header("Location").IsEqual(url)
+
+	// An atomic batch with a taken alias saves nothing.
+	other := gofakeit.LetterN(10)
+	e.POST("/url/batch").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{
+			"atomic": true,
+			"items": []map[string]string{
+				{"url": gofakeit.URL(), "alias": other},
+				{"url": gofakeit.URL(), "alias": alias},
+			},
+		}).
+		Expect().
+		Status(http.StatusUnprocessableEntity).
+		JSON().Object().
+		Value("items").Array().Value(1).Object().
+		HasValue("error", "alias already exists")
+
+	e.GET("/{alias}", other).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(404)
+
+	tooMany := make([]map[string]string, testMaxBatch+1)
+	for i := range tooMany {
+		tooMany[i] = map[string]string{"url": gofakeit.URL()}
+	}
+	e.POST("/url/batch").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"items": tooMany}).
+		Expect().
+		Status(400)
+
+	e.POST("/url/batch").
+		WithJSON(map[string]any{"items": []map[string]string{{"url": gofakeit.URL()}}}).
+		Expect().
+		Status(401)
+}
+
+func TestURLShortener_BatchDelete(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	aliases := []string{gofakeit.LetterN(10), gofakeit.LetterN(10)}
+	for _, alias := range aliases {
+		e.POST("/url").
+			WithBasicAuth(testUser, testPassword).
+			WithJSON(map[string]string{
+				"url":   gofakeit.URL(),
+				"alias": alias,
+			}).
+			Expect().
+			Status(200)
+	}
+
+	missing := gofakeit.LetterN(10)
+	res := e.POST("/url/batch/delete").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"aliases": append(aliases, missing)}).
+		Expect().
+		Status(200).
+		JSON().Object()
+
+	res.HasValue("status", "OK").HasValue("deleted", 2)
+	res.Value("not_found").Array().IsEqual([]string{missing})
+
+	for _, alias := range aliases {
+		e.GET("/{alias}", alias).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			Expect().
+			Status(404)
+	}
+
+	e.POST("/url/batch/delete").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{}).
+		Expect().
+		Status(400)
+
+	e.POST("/url/batch/delete").
+		WithJSON(map[string]any{"aliases": aliases}).
+		Expect().
+		Status(401)
+}
+
+func TestURLShortener_DebugVars(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.GET("/debug/vars").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		ContainsKey("alias_collisions").
+		ContainsKey("alias_exhausted")
+
+	e.GET("/debug/vars").
+		Expect().
+		Status(401)
+}
+
+func TestURLShortener_Deduplicate(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	url := gofakeit.URL()
+
+	alias := e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": url, "deduplicate": true}).
+		Expect().
+		Status(200).
+		JSON().Object().
+		NotContainsKey("existing").
+		Value("alias").String().Raw()
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": url, "deduplicate": true}).
+		Expect().
+		Status(200).
+		JSON().Object().
+		HasValue("alias", alias).
+		HasValue("existing", true)
+
+	// Deduplication is off by default in the test server.
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": url}).
+		Expect().
+		Status(200).
+		JSON().Object().
+		NotContainsKey("existing").
+		Value("alias").String().NotEqual(alias)
+
+	// A different fragment is a different destination.
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": url + "#b", "deduplicate": true}).
+		Expect().
+		Status(200).
+		JSON().Object().
+		NotContainsKey("existing").
+		Value("alias").String().NotEqual(alias)
+}
+
+func TestURLShortener_InvalidAlias(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	for alias, wantError := range map[string]string{
+		"url":      "field Alias is a reserved word",
+		"a/b/c":    "field Alias may only contain the characters a-zA-Z0-9_-",
+		"ab":       "field Alias must be at least 3 characters long",
+		"DEBUG":    "field Alias is a reserved word",
+		"ok-alias": "",
+	} {
+		res := e.POST("/url").
+			WithBasicAuth(testUser, testPassword).
+			WithJSON(map[string]string{"url": gofakeit.URL(), "alias": alias}).
+			Expect()
+
+		if wantError == "" {
+			res.Status(200)
+			continue
+		}
+
+		res.Status(400).
+			JSON().Object().
+			HasValue("error", wantError)
+	}
+}
+
+func TestURLShortener_RedirectStatus(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	url := gofakeit.URL()
+
+	for alias, status := range map[string]int{"perm-301": 301, "temp-307": 307, "perm-308": 308} {
+		e.POST("/url").
+			WithBasicAuth(testUser, testPassword).
+			WithJSON(map[string]any{"url": url, "alias": alias, "redirect_status": status}).
+			Expect().
+			Status(200)
+
+		res := e.GET("/{alias}", alias).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			Expect().
+			Status(status)
+
+		res.Header("Location").IsEqual(url)
+		if status == 307 {
+			res.Header("Cache-Control").IsEmpty()
+		} else {
+			res.Header("Cache-Control").IsEqual("public, max-age=3600")
+		}
+	}
+
+	// 307 and 308 tell clients to repeat the method, so POST is redirected too.
+	for alias, status := range map[string]int{"temp-307": 307, "perm-308": 308} {
+		e.POST("/{alias}", alias).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			Expect().
+			Status(status).
+			Header("Location").IsEqual(url)
+	}
+
+	// Links with a status of their own are not reused for plain ones.
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": url, "deduplicate": true}).
+		Expect().
+		Status(200).
+		JSON().Object().
+		NotContainsKey("existing")
+
+	e.GET("/url/{alias}", "perm-301").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		HasValue("redirect_status", 301)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": url, "redirect_status": 303}).
+		Expect().
+		Status(400).
+		JSON().Object().
+		HasValue("error", "field RedirectStatus must be one of 301 302 307 308")
+}
+
+func TestURLShortener_Passthrough(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": "https://example.com/docs?src=short", "alias": "docs", "passthrough": true}).
+		Expect().
+		Status(200)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": "https://example.com/plain", "alias": "plain"}).
+		Expect().
+		Status(200)
+
+	e.GET("/docs/guide/start").
+		WithQuery("src", "visitor").
+		WithQuery("lang", "en").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(302).
+		Header("Location").IsEqual("https://example.com/docs/guide/start?src=short&lang=en")
+
+	// Without passthrough the query is ignored and subpaths do not resolve.
+	e.GET("/plain").
+		WithQuery("lang", "en").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(302).
+		Header("Location").IsEqual("https://example.com/plain")
+
+	e.GET("/plain/extra").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(404)
+
+	e.GET("/url/{alias}", "docs").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		HasValue("passthrough", true)
+}
+
+func TestURLShortener_UTM(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.PUT("/url/utm-templates/{name}", "newsletter").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{"source": "newsletter", "medium": "email", "campaign": "default"}).
+		Expect().
+		Status(200)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{
+			"url":          "https://example.com/sale",
+			"alias":        "sale",
+			"utm_template": "newsletter",
+			"utm":          map[string]string{"campaign": "spring"},
+		}).
+		Expect().
+		Status(200)
+
+	e.GET("/sale").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(302).
+		Header("Location").IsEqual("https://example.com/sale?utm_campaign=spring&utm_medium=email&utm_source=newsletter")
+
+	// The stored destination stays clean.
+	info := e.GET("/url/{alias}", "sale").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object()
+	info.HasValue("url", "https://example.com/sale")
+	info.HasValue("utm_template", "newsletter")
+	info.Value("utm").Object().HasValue("campaign", "spring")
+
+	// Template changes apply to existing links.
+	e.PUT("/url/utm-templates/{name}", "newsletter").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{"source": "weekly"}).
+		Expect().
+		Status(200)
+
+	e.GET("/sale").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(302).
+		Header("Location").IsEqual("https://example.com/sale?utm_campaign=spring&utm_source=weekly")
+
+	e.GET("/url/utm-templates").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		Value("templates").Array().Length().IsEqual(1)
+
+	e.DELETE("/url/utm-templates/{name}", "newsletter").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200)
+
+	e.GET("/url/utm-templates/{name}", "newsletter").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(404)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{"url": "https://example.com", "alias": "utm-templates"}).
+		Expect().
+		Status(400).
+		JSON().Object().
+		HasValue("error", "field Alias is a reserved word")
+}
+
+func TestURLShortener_Password(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]string{"url": "https://example.com/report", "alias": "report", "password": "s3cret"}).
+		Expect().
+		Status(200)
+
+	info := e.GET("/url/{alias}", "report").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object()
+	info.HasValue("password_protected", true)
+	info.NotContainsKey("password")
+	info.NotContainsKey("password_hash")
+
+	e.GET("/report").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(200).
+		ContentType("text/html").
+		Body().Contains(`name="password"`)
+
+	e.POST("/report").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		WithFormField("password", "guess").
+		Expect().
+		Status(403).
+		Body().Contains("Wrong password.")
+
+	e.POST("/report").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		WithFormField("password", "s3cret").
+		Expect().
+		Status(303).
+		Header("Location").IsEqual("https://example.com/report")
+
+	for range 3 {
+		e.POST("/report").
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithFormField("password", "guess").
+			Expect().
+			Status(403)
+	}
+
+	e.POST("/report").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		WithFormField("password", "s3cret").
+		Expect().
+		Status(429).
+		Header("Retry-After").NotEmpty()
+}
+
+func TestURLShortener_MaxClicks(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": "https://example.com/invite", "alias": "invite", "max_clicks": 2}).
+		Expect().
+		Status(200)
+
+	for range 2 {
+		e.GET("/invite").
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			Expect().
+			Status(302).
+			Header("Location").IsEqual("https://example.com/invite")
+	}
+
+	e.GET("/invite").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(410).
+		JSON().Object().
+		HasValue("error", "url click limit reached")
+
+	info := e.GET("/url/{alias}", "invite").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object()
+	info.HasValue("max_clicks", 2)
+	info.HasValue("clicks_left", 0)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": "https://example.com", "max_clicks": 0}).
+		Expect().
+		Status(200)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": "https://example.com", "max_clicks": -1}).
+		Expect().
+		Status(400).
+		JSON().Object().
+		HasValue("error", "field MaxClicks must be greater than 0")
+}
+
+func TestURLShortener_NotBefore(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	notBefore := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": "https://example.com/launch", "alias": "launch", "not_before": notBefore}).
+		Expect().
+		Status(200)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{
+			"url":          "https://example.com/launch",
+			"alias":        "teaser",
+			"not_before":   notBefore,
+			"fallback_url": "https://example.com/coming-soon",
+		}).
+		Expect().
+		Status(200)
+
+	// Until launch the link looks like any unknown alias.
+	e.GET("/launch").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(404).
+		JSON().Object().
+		HasValue("error", "not found")
+
+	e.GET("/teaser").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(302).
+		Header("Location").IsEqual("https://example.com/coming-soon")
+
+	e.GET("/url/{alias}", "teaser").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		HasValue("not_before", notBefore.Format(time.RFC3339)).
+		HasValue("fallback_url", "https://example.com/coming-soon")
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{"url": "https://example.com", "not_before": notBefore, "expires_in": 60}).
+		Expect().
+		Status(400).
+		JSON().Object().
+		HasValue("error", "field NotBefore must be before the expiry")
+}
+
+func TestURLShortener_Targets(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{
+			"url":   "https://example.com/app",
+			"alias": "app",
+			"targets": []map[string]string{
+				{"platform": "ios", "url": "https://apps.apple.com/app/id1"},
+				{"platform": "android", "url": "https://play.google.com/store/apps/details?id=app"},
+			},
+		}).
+		Expect().
+		Status(200)
+
+	visit := func(ua string) *httpexpect.Response {
+		return e.GET("/app").
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithHeader("User-Agent", ua).
+			Expect().
+			Status(302)
+	}
+
+	visit("Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) Mobile/15E148").
+		Header("Location").IsEqual("https://apps.apple.com/app/id1")
+	visit("Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36").
+		Header("Location").IsEqual("https://play.google.com/store/apps/details?id=app")
+
+	res := visit("Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/124.0.0.0")
+	res.Header("Location").IsEqual("https://example.com/app")
+	res.Header("Vary").IsEqual("User-Agent")
+
+	e.GET("/url/{alias}", "app").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		Value("targets").Array().Length().IsEqual(2)
+}
+
+func TestURLShortener_CountryTargets(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{
+			"url":   "https://example.com/shop",
+			"alias": "shop",
+			"country_targets": []map[string]any{
+				{"countries": []string{"GB", "IE"}, "url": "https://example.co.uk/shop"},
+				{"countries": []string{"SE"}, "url": "https://example.se/shop"},
+			},
+		}).
+		Expect().
+		Status(200)
+
+	visit := func(forwardedFor string) *httpexpect.Response {
+		return e.GET("/shop").
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithHeader("X-Forwarded-For", forwardedFor).
+			Expect().
+			Status(302)
+	}
+
+	visit("81.2.69.142").Header("Location").IsEqual("https://example.co.uk/shop")
+	visit("89.160.20.112").Header("Location").IsEqual("https://example.se/shop")
+	// Unknown to the database.
+	visit("198.51.100.1").Header("Location").IsEqual("https://example.com/shop")
+	// Only the hop the proxy saw counts, not what the client claims.
+	visit("89.160.20.112, 81.2.69.142").Header("Location").IsEqual("https://example.co.uk/shop")
+
+	e.GET("/url/{alias}", "shop").
+		WithBasicAuth(testUser, testPassword).
+		Expect().
+		Status(200).
+		JSON().Object().
+		Value("country_targets").Array().Length().IsEqual(2)
+
+	e.POST("/url").
+		WithBasicAuth(testUser, testPassword).
+		WithJSON(map[string]any{
+			"url":             "https://example.com/shop",
+			"country_targets": []map[string]any{{"countries": []string{"XX"}, "url": "https://example.com/xx"}},
+		}).
+		Expect().
+		Status(400).
+		JSON().Object().
+		Value("error").String().IsEqual("field Countries[0] must be an ISO 3166-1 alpha-2 country code")
+}